@@ -515,24 +515,7 @@ func get_tokens() (string, string, error) {
 
 	//************************************************************
 
-	if isWindows() == true {
-		chrome, err := FindChromeBrowser()
-
-		var cmd *exec.Cmd
-
-		if err == nil {
-			cmd = exec.Command(chrome, url)
-
-			err = cmd.Start()
-		} else {
-			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
-		}
-
-		err = cmd.Start()
-	} else {
-		// This requires that Linux has a desktop
-		err = exec.Command("xdg-open", url).Start()
-	}
+	err = open_browser(url)
 
 	if err != nil {
 		fmt.Println(err)
@@ -616,6 +599,30 @@ func get_sa_tokens() (string, string, error) {
 	return token.AccessToken, "", nil
 }
 
+func open_browser(url string) error {
+	//************************************************************
+	// Open the URL with Chrome on Windows, or the default browser
+	// if Chrome is not installed. On Linux use xdg-open.
+	//************************************************************
+
+	if isWindows() == true {
+		chrome, err := FindChromeBrowser()
+
+		var cmd *exec.Cmd
+
+		if err == nil {
+			cmd = exec.Command(chrome, url)
+		} else {
+			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+		}
+
+		return cmd.Start()
+	}
+
+	// This requires that Linux has a desktop
+	return exec.Command("xdg-open", url).Start()
+}
+
 func FindChromeBrowser() (string, error) {
 	// Web browser to launch to authenticate
 	// This path is valid for Windows x64 only
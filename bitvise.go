@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os/exec"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
 // Bitvise parameter list
@@ -12,11 +14,12 @@ import (
 
 var path_bitvise =  "C:\\Program Files (x86)\\Bitvise SSH Client\\BvSsh.exe"
 
-func exec_bitvise(params CloudShellEnv) {
+func exec_bitvise(params cloudshell.Environment) {
 	key, err := env_get_ssh_ppk()
 
 	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
+		fmt.Println("\nTip: Run the command: \"cloudshell keys init\" to setup Cloud Shell SSH keys")
+		set_exit_code(1)
 		return
 	}
 
@@ -50,6 +53,7 @@ func exec_bitvise(params CloudShellEnv) {
 
 	if err != nil {
 		fmt.Println(err)
+		set_exit_code(1)
 		return
 	}
 }
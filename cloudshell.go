@@ -1,187 +1,94 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"time"
-	"github.com/kirinlabs/HttpRequest"
-)
-
-//******************************************************************************************
-// Cloud Shell State
-//
-// https://cloud.google.com/shell/docs/reference/rest/Shared.Types/State
-//
-// STATE_UNSPECIFIED	The environment's states is unknown.
-// DISABLED		The environment is not running and can't be connected to.
-//			Starting the environment will transition it to the STARTING state.
-// STARTING		The environment is being started but is not yet ready to accept
-//			connections.
-// RUNNING		The environment is running and ready to accept connections. It
-//			will automatically transition back to DISABLED after a period of
-//			inactivity or if another environment is started.
-//******************************************************************************************
-
-//******************************************************************************************
-// https://cloud.google.com/shell/docs/reference/rest/Shared.Types/Environment
-//******************************************************************************************
-
-type CloudShellEnv struct {
-	Name                 string   `json:"name"`
-	Id                   string   `json:"id"`
-	DockerImage          string   `json:"dockerImage"`
-	State                string   `json:"state"`
-	SshUsername          string   `json:"sshUsername"`
-	SshHost              string   `json:"sshHost"`
-	SshPort              int32    `json:"sshPort"`
-	Error struct {
-		Code              int32   `json:"code"`
-		Message           string  `json:"message"`
-		Status            string  `json:"status"`
-	} `json:"error"`
-}
-
-//******************************************************************************************
-// Method: users.environments.get
-// https://cloud.google.com/shell/docs/reference/rest/v1alpha1/users.environments/get
-//******************************************************************************************
-
-func cloud_shell_get_environment(accessToken string, flag_info bool) (CloudShellEnv, error) {
-	//************************************************************
-	//
-	//************************************************************
-
-	var params CloudShellEnv
 
-	endpoint := "https://cloudshell.googleapis.com/v1alpha1/users/me/environments/default"
-	endpoint += "?alt=json"
-
-	req := HttpRequest.NewRequest()
-
-	hdrs := map[string]string {
-			"Authorization": "Bearer " + accessToken,
-			"X-Goog-User-Project": config.ProjectId,
-	}
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+	"golang.org/x/oauth2"
+)
 
-	req.SetHeaders(hdrs)
-
-	if config.Debug == true {
-		fmt.Println("Access Token:", accessToken)
-		fmt.Println("ProjectId:", config.ProjectId)
-	}
+// In-memory SSH key for --ephemeral-key, registered for this session only
+var ephemeral_key *cloudshell.EphemeralKey
 
+func new_cloudshell_client(accessToken string) (*cloudshell.Client, error) {
 	//************************************************************
-	//
+	// The access token was obtained by get_tokens() and is valid
+	// for at least the next 15 minutes
 	//************************************************************
 
-	res, err := req.Get(endpoint)
-
-	if err != nil {
-		fmt.Println("Error: ", err)
-		return params, err
-	}
-
-	body, err := res.Body()
-
-	if err != nil {
-		fmt.Println("Error: ", err)
-		return params, err
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: accessToken})
+
+	opts := &cloudshell.Options{
+		APIVersion: config.ApiVersion,
+		WaitTimeout: config.Flags.WaitTimeout,
+		OnStateChange: func(from, to string) {
+			if from == "" {
+				if to != cloudshell.StateRunning {
+					fmt.Println("CloudShell State:", to)
+				}
+			} else {
+				fmt.Println("CloudShell State:", from, "->", to)
+			}
+		},
 	}
 
-	if flag_info == true {
-		fmt.Println("")
-		fmt.Println("************************************************************")
-		fmt.Println("Cloud Shell Info:")
-		fmt.Println(string(body))
-		fmt.Println("************************************************************")
+	if key, err := env_get_ssh_pkey_path(); err == nil {
+		opts.KeyFile = key
 	}
 
-	err = json.Unmarshal(body, &params)
+	opts.AuthOrder = config.AuthOrder
+	opts.Passphrase = key_passphrase
 
-	if err != nil {
-		fmt.Println("Error: Cannot unmarshal JSON: ", err)
-		return params, err
-	}
+	// Restore the terminal when the program is terminated during a shell
+	opts.OnRawTerminal = at_exit
+	opts.HostKeyPolicy = host_key_policy()
 
-	if params.Error.Code != 0 {
-		fmt.Println("")
-		fmt.Println(params.Error.Message)
+	if file, err := env_get_known_hosts_path(); err == nil {
+		opts.KnownHostsFile = file
 	}
 
-	return params, nil
-}
-
-//******************************************************************************************
-// Method: users.environment.start
-// https://cloud.google.com/shell/docs/reference/rest/v1alpha1/users.environments/start
-//******************************************************************************************
-
-func cloudshell_start(accessToken string) error {
-	//************************************************************
-	//
-	//************************************************************
-
 	if config.Debug == true {
-		fmt.Println("Request users.environment.start")
-	}
-
-	endpoint := "https://cloudshell.googleapis.com/v1alpha1/users/me/environments/default"
-	endpoint += ":start"
-	endpoint += "?alt=json"
-
-	req := HttpRequest.NewRequest()
-
-	hdrs := map[string]string {
-			"Authorization": "Bearer " + accessToken,
-			"X-Goog-User-Project": config.ProjectId,
-	}
-
-	req.SetHeaders(hdrs)
-
-	//************************************************************
-	//
-	//************************************************************
-
-	res, err := req.Post(endpoint)
-
-	if err != nil {
-		fmt.Println("Error: ", err)
-		return err
+		opts.Logf = func(format string, v ...interface{}) {
+			fmt.Printf(format + "\n", v...)
+		}
 	}
 
-	body, err := res.Body()
+	return cloudshell.NewClient(ts, config.ProjectId, opts)
+}
 
-	if err != nil {
-		fmt.Println("Error: ", err)
-		return err
-	}
+func print_ssh_error(err error) {
+	fmt.Println("Error:", err)
+	set_exit_code(1)
 
-	if config.Debug == true {
-		fmt.Println("")
-		fmt.Println("************************************************************")
-		fmt.Println("Cloud Shell Info:")
-		fmt.Println(string(body))
-		fmt.Println("************************************************************")
+	if errors.Is(err, cloudshell.ErrKeyNotFound) {
+		fmt.Println("\nTip: Run the command: \"cloudshell keys init\" to setup Cloud Shell SSH keys")
 	}
+}
 
-	var params CloudShellEnv
+func env_get_ssh_pkey_path() (string, error) {
+	//*************************************************************
+	// Return the path of the Google Cloud SSH Key for the current
+	// user. The file may not exist.
+	//*************************************************************
 
-	err = json.Unmarshal(body, &params)
+	path, err := get_home_directory()
 
 	if err != nil {
-		fmt.Println("Error: Cannot unmarshal JSON: ", err)
-		return err
+		return "", err
 	}
 
-	if params.Error.Code != 0 {
-		err = errors.New(params.Error.Message)
-		fmt.Println("Error Code:", params.Error.Code)
-		fmt.Println(err)
-		return err
+	if isWindows() == true {
+		path += "\\.ssh\\google_compute_engine"
+	} else {
+		path += "/.ssh/google_compute_engine"
 	}
 
-	return nil
+	return path, nil
 }
 
 func env_get_ssh_pkey() (string, error) {
@@ -189,19 +96,13 @@ func env_get_ssh_pkey() (string, error) {
 	// Return the Google Cloud SSH Key for the current Windows User
 	//*************************************************************
 
-	path, err := get_home_directory()
+	path, err := env_get_ssh_pkey_path()
 
 	if err != nil {
 		fmt.Println(err)
 		return "", err
 	}
 
-	if isWindows() == true {
-		path += "\\.ssh\\google_compute_engine"
-	} else {
-		path += "/.ssh/google_compute_engine"
-	}
-
 	if config.Debug == true {
 		fmt.Println("Path:", path)
 	}
@@ -219,25 +120,36 @@ func env_get_ssh_pkey() (string, error) {
 func env_get_ssh_ppk() (string, error) {
 	//*************************************************************
 	// Return the Google Cloud SSH Key for the current Windows User
+	// in PuTTY format. The .ppk is created from the OpenSSH key
+	// if it does not exist or is older than the OpenSSH key.
 	//*************************************************************
 
-	path, err := get_home_directory()
+	key, err := env_get_ssh_pkey_path()
 
 	if err != nil {
 		fmt.Println(err)
 		return "", err
 	}
 
-	if isWindows() == true {
-		path += "\\.ssh\\google_compute_engine.ppk"
-	} else {
-		path += "/.ssh/google_compute_engine.ppk"
-	}
+	path := key + ".ppk"
 
 	if config.Debug == true {
 		fmt.Println("Path:", path)
 	}
 
+	if ppk_is_stale(key, path) == true {
+		err = cloudshell.ConvertToPPK(key, path, key_passphrase, nil, config.PpkVersion)
+
+		if err != nil {
+			fmt.Println("Error: Cannot create PuTTY key:", err)
+			return "", err
+		}
+
+		if config.Debug == true {
+			fmt.Println("Created:", path)
+		}
+	}
+
 	if fileExists(path) == false {
 		err = errors.New("Google SSH Key does not exist")
 		fmt.Println("Error:", err)
@@ -248,73 +160,128 @@ func env_get_ssh_ppk() (string, error) {
 	return path, nil
 }
 
+// ppk_is_stale reports whether the .ppk file must be (re)created from the
+// OpenSSH private key.
+func ppk_is_stale(key string, ppk string) bool {
+	keyInfo, err := os.Stat(key)
+
+	if err != nil {
+		return false
+	}
+
+	ppkInfo, err := os.Stat(ppk)
+
+	if err != nil {
+		return true
+	}
+
+	return ppkInfo.ModTime().Before(keyInfo.ModTime())
+}
+
 func call_cloud_shell(accessToken string) {
 	//************************************************************
 	//
 	//************************************************************
 
-	flag_info := false
+	ctx := context.Background()
 
-	if config.Command == CMD_INFO {
-		flag_info = true
-	}
+	client, err := new_cloudshell_client(accessToken)
 
-	var params CloudShellEnv
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
 
-	params, err := cloud_shell_get_environment(accessToken, flag_info)
+	if config.Command == CMD_KEYS {
+		cmd_keys(ctx, client)
+		return
+	}
 
-	if err != nil {
+	if config.Command == CMD_HOSTKEYS {
+		cmd_hostkeys(ctx, client)
 		return
 	}
 
 	if config.Command == CMD_INFO {
+		params, err := client.GetEnvironment(ctx)
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+
+		b, _ := json.MarshalIndent(params, "", "  ")
+
+		fmt.Println("")
+		fmt.Println("************************************************************")
+		fmt.Println("Cloud Shell Info:")
+		fmt.Println(string(b))
+		fmt.Println("************************************************************")
 		return
 	}
 
-	if params.State == "DISABLED" {
-		fmt.Println("CloudShell State:", params.State)
+	if config.Flags.EphemeralKey == true {
+		//************************************************************
+		// Register an in-memory key for this session. The public key
+		// is removed on exit, including on Ctrl-C. The handler is set
+		// up first, so that Ctrl-C during the registration waits for
+		// it and removes the key.
+		//************************************************************
 
-		err = cloudshell_start(accessToken)
+		key, err := client.NewEphemeralKey(default_key_type())
 
 		if err != nil {
+			fmt.Println("Error: Cannot create ephemeral key:", err)
+			set_exit_code(1)
 			return
 		}
 
-		for x := 0; x < 60; x++ {
-			time.Sleep(500 * time.Millisecond)
+		at_exit(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+			defer cancel()
 
-			params, err = cloud_shell_get_environment(accessToken, flag_info)
+			err := key.Close(ctx)
 
 			if err != nil {
-				return
+				fmt.Println("Error: Cannot remove ephemeral key:", err)
+			} else if config.Debug == true {
+				fmt.Println("Ephemeral key removed")
 			}
+		})
 
-			if params.Error.Code != 0 {
-				return
-			}
+		err = key.Register(ctx)
 
-			if params.State == "RUNNING" {
-				// FIX
-				// Sleep to allow the CloudShell VM to start responding
-				// I don't know how long this really takes
-				// Perhaps a connection attempt is required
-				time.Sleep(5000 * time.Millisecond)
-				break;
-			}
+		if err != nil {
+			fmt.Println("Error: Cannot register ephemeral key:", err)
+			set_exit_code(1)
+			return
 		}
+
+		ephemeral_key = key
 	}
 
-	if params.State != "RUNNING" {
-		fmt.Println("CloudShell State:", params.State)
+	params, err := client.WaitUntilRunning(ctx)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
 		return
 	}
 
-	if config.Command == CMD_PUTTY {
-		exec_putty(params)
+	if ephemeral_key != nil {
+		err = client.WaitForKey(ctx, params, ephemeral_key.Signer)
+
+		if err != nil {
+			fmt.Println("Error: Ephemeral key not accepted:", err)
+			set_exit_code(1)
+			return
+		}
 	}
 
-	if config.Command == CMD_INLINE_SSH {
-		exec_inline_ssh(params)
+	if config.Command == CMD_PUTTY {
+		exec_putty(params)
 	}
 
 	if config.Command == CMD_WINSSH {
@@ -322,27 +289,47 @@ func call_cloud_shell(accessToken string) {
 	}
 
 	if config.Command == CMD_SSH {
-		exec_ssh(params)
+		exec_ssh(ctx, client, params)
 	}
 
 	if config.Command == CMD_EXEC {
-		exec_command(params)
+		exec_command(ctx, client, params)
+	}
+
+	if config.Command == CMD_RUN {
+		cmd_run(ctx, client, params)
 	}
 
 	if config.Command == CMD_DOWNLOAD {
-		sftp_download(params)
+		sftp_download(ctx, client, params)
 	}
 
 	if config.Command == CMD_UPLOAD {
-		sftp_upload(params)
+		sftp_upload(ctx, client, params)
+	}
+
+	if config.Command == CMD_SYNC {
+		cmd_sync(ctx, client, params)
+	}
+
+	if config.Command == CMD_FORWARD {
+		cmd_forward(ctx, client, params)
+	}
+
+	if config.Command == CMD_SOCKS {
+		cmd_socks(ctx, client, params)
+	}
+
+	if config.Command == CMD_PREVIEW {
+		cmd_preview(ctx, client, params)
 	}
 
 	if config.Command == CMD_BENCHMARK_DOWNLOAD {
-		sftp_benchmark_download(params)
+		sftp_benchmark_download(ctx, client, params)
 	}
 
 	if config.Command == CMD_BENCHMARK_UPLOAD {
-		sftp_benchmark_upload(params)
+		sftp_benchmark_upload(ctx, client, params)
 	}
 
 	if config.Command == CMD_BITVISE {
@@ -350,6 +337,6 @@ func call_cloud_shell(accessToken string) {
 	}
 
 	if config.Command == CMD_WINSCP {
-		exec_winscp(params)
+		exec_winscp(ctx, client, params)
 	}
 }
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package cloudshell
+
+import (
+	"io"
+	"net"
+	"os"
+)
+
+// defaultAgentSocket returns $SSH_AUTH_SOCK.
+func defaultAgentSocket() string {
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+func dialAgent(socket string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", socket)
+}
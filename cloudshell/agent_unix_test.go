@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package cloudshell
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgent(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	l, err := net.Listen("unix", socket)
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	defer l.Close()
+
+	keyring := agent.NewKeyring()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ssh.NewPublicKey(key.Public())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	a, conn, err := Agent(socket)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	signers, err := a.Signers()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(signers) != 1 || sameKeys([]ssh.PublicKey{signers[0].PublicKey()}, []ssh.PublicKey{pub}) == false {
+		t.Errorf("agent has %d keys", len(signers))
+	}
+}
+
+func TestAgentNotRunning(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	if _, _, err := Agent(""); errors.Is(err, ErrAgentNotRunning) == false {
+		t.Errorf("err = %v, want %v", err, ErrAgentNotRunning)
+	}
+
+	_, _, err := Agent(filepath.Join(t.TempDir(), "missing.sock"))
+
+	if err == nil || errors.Is(err, ErrAgentNotRunning) {
+		t.Errorf("missing socket: err = %v", err)
+	}
+}
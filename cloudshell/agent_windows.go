@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+package cloudshell
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// The OpenSSH agent of Windows listens on a named pipe
+const windowsAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// defaultAgentSocket returns $SSH_AUTH_SOCK, or the pipe of the OpenSSH
+// agent when it is not set.
+func defaultAgentSocket() string {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		return socket
+	}
+
+	return windowsAgentPipe
+}
+
+// dialAgent opens a named pipe, or a Unix socket of an agent that supports
+// them.
+func dialAgent(socket string) (io.ReadWriteCloser, error) {
+	if strings.HasPrefix(strings.ToLower(socket), `\\.\pipe\`) == false {
+		return net.Dial("unix", socket)
+	}
+
+	f, err := os.OpenFile(socket, os.O_RDWR, 0)
+
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w (%s not found, start the ssh-agent service)", ErrAgentNotRunning, socket)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,93 @@
+package cloudshell
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Cloud Shell API versions supported by Options.APIVersion.
+const (
+	APIVersionV1       = "v1"
+	APIVersionV1Alpha1 = "v1alpha1"
+)
+
+const endpointBase = "https://cloudshell.googleapis.com/"
+
+// ErrPublicKeyNotFound is returned when removing a public key that is not
+// associated with the environment.
+var ErrPublicKeyNotFound = errors.New("cloudshell: public key not found")
+
+// api is implemented by each version of the Cloud Shell REST API.
+type api interface {
+	getEnvironment() (Environment, error)
+	start(req *StartRequest) (*Operation, error)
+	authorize(req AuthorizeRequest) (*Operation, error)
+	addPublicKey(key string) (*Operation, error)
+	removePublicKey(key string) (*Operation, error)
+	getOperation(name string) (*Operation, error)
+}
+
+func newAPI(c *Client, version string) (api, error) {
+	switch version {
+	case "", APIVersionV1Alpha1:
+		return &apiV1Alpha1{c: c}, nil
+
+	case APIVersionV1:
+		return &apiV1{c: c}, nil
+	}
+
+	return nil, errors.New("cloudshell: unsupported API version: " + version)
+}
+
+// normalizeKey parses a public key in OpenSSH authorized_keys format and
+// returns it as "<format> <base64>", without options or comment.
+func normalizeKey(key string) (string, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+
+	if err != nil {
+		return "", fmt.Errorf("cloudshell: invalid public key: %w", err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))), nil
+}
+
+// authorizeBody is the JSON body of the authorize method.
+type authorizeBody struct {
+	AccessToken string `json:"accessToken,omitempty"`
+	IdToken     string `json:"idToken,omitempty"`
+	ExpireTime  string `json:"expireTime,omitempty"`
+}
+
+func newAuthorizeBody(req AuthorizeRequest) authorizeBody {
+	body := authorizeBody{
+		AccessToken: req.AccessToken,
+		IdToken:     req.IdToken,
+	}
+
+	if req.ExpireTime.IsZero() == false {
+		body.ExpireTime = req.ExpireTime.UTC().Format(time.RFC3339)
+	}
+
+	return body
+}
+
+//******************************************************************************************
+// Operations are only served by the v1 API
+// https://cloud.google.com/shell/docs/reference/rest/v1/operations/get
+//******************************************************************************************
+
+func getOperation(c *Client, name string) (*Operation, error) {
+	var op Operation
+
+	err := c.do("GET", endpointBase+APIVersionV1+"/"+name+"?alt=json", nil, &op)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
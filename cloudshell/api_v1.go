@@ -0,0 +1,68 @@
+package cloudshell
+
+//******************************************************************************************
+// Cloud Shell API v1
+// https://cloud.google.com/shell/docs/reference/rest/v1/users.environments
+//******************************************************************************************
+
+const endpointV1Environment = endpointBase + APIVersionV1 + "/users/me/environments/default"
+
+type apiV1 struct {
+	c *Client
+}
+
+func (a *apiV1) getEnvironment() (Environment, error) {
+	var env Environment
+
+	err := a.c.do("GET", endpointV1Environment+"?alt=json", nil, &env)
+
+	return env, err
+}
+
+func (a *apiV1) post(method string, body interface{}) (*Operation, error) {
+	var op Operation
+
+	err := a.c.do("POST", endpointV1Environment+":"+method+"?alt=json", body, &op)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+func (a *apiV1) start(req *StartRequest) (*Operation, error) {
+	if req == nil {
+		req = &StartRequest{}
+	}
+
+	return a.post("start", req)
+}
+
+func (a *apiV1) authorize(req AuthorizeRequest) (*Operation, error) {
+	return a.post("authorize", newAuthorizeBody(req))
+}
+
+func (a *apiV1) addPublicKey(key string) (*Operation, error) {
+	key, err := normalizeKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a.post("addPublicKey", map[string]string{"key": key})
+}
+
+func (a *apiV1) removePublicKey(key string) (*Operation, error) {
+	key, err := normalizeKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a.post("removePublicKey", map[string]string{"key": key})
+}
+
+func (a *apiV1) getOperation(name string) (*Operation, error) {
+	return getOperation(a.c, name)
+}
@@ -0,0 +1,180 @@
+package cloudshell
+
+import (
+	"errors"
+	"strings"
+)
+
+//******************************************************************************************
+// Cloud Shell API v1alpha1
+// https://cloud.google.com/shell/docs/reference/rest/v1alpha1/users.environments
+//******************************************************************************************
+
+const endpointV1Alpha1Environment = endpointBase + APIVersionV1Alpha1 + "/users/me/environments/default"
+
+type apiV1Alpha1 struct {
+	c *Client
+}
+
+// v1alpha1 public keys are resources with a format enum and the base64 key
+// https://cloud.google.com/shell/docs/reference/rest/Shared.Types/PublicKey
+type alphaPublicKey struct {
+	Name   string `json:"name,omitempty"`
+	Format string `json:"format"`
+	Key    string `json:"key"`
+}
+
+var alphaKeyFormats = map[string]string{
+	"SSH_DSS":             "ssh-dss",
+	"SSH_RSA":             "ssh-rsa",
+	"ECDSA_SHA2_NISTP256": "ecdsa-sha2-nistp256",
+	"ECDSA_SHA2_NISTP384": "ecdsa-sha2-nistp384",
+	"ECDSA_SHA2_NISTP521": "ecdsa-sha2-nistp521",
+}
+
+func (k alphaPublicKey) String() string {
+	return alphaKeyFormats[k.Format] + " " + k.Key
+}
+
+func newAlphaPublicKey(key string) (alphaPublicKey, error) {
+	key, err := normalizeKey(key)
+
+	if err != nil {
+		return alphaPublicKey{}, err
+	}
+
+	fields := strings.Fields(key)
+
+	for format, name := range alphaKeyFormats {
+		if name == fields[0] {
+			return alphaPublicKey{Format: format, Key: fields[1]}, nil
+		}
+	}
+
+	return alphaPublicKey{}, errors.New("cloudshell: v1alpha1 does not support " + fields[0] + " keys")
+}
+
+type alphaEnvironment struct {
+	Environment
+	PublicKeys []alphaPublicKey `json:"publicKeys"`
+}
+
+func (a *apiV1Alpha1) environment() (alphaEnvironment, error) {
+	var env alphaEnvironment
+
+	err := a.c.do("GET", endpointV1Alpha1Environment+"?alt=json", nil, &env)
+
+	return env, err
+}
+
+func (a *apiV1Alpha1) getEnvironment() (Environment, error) {
+	alpha, err := a.environment()
+
+	env := alpha.Environment
+	env.PublicKeys = nil
+
+	for _, key := range alpha.PublicKeys {
+		env.PublicKeys = append(env.PublicKeys, key.String())
+	}
+
+	return env, err
+}
+
+func (a *apiV1Alpha1) start(req *StartRequest) (*Operation, error) {
+	body := map[string]string{}
+
+	if req != nil {
+		for _, key := range req.PublicKeys {
+			_, err := a.addPublicKey(key)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if req.AccessToken != "" {
+			body["accessToken"] = req.AccessToken
+		}
+	}
+
+	var op Operation
+
+	err := a.c.do("POST", endpointV1Alpha1Environment+":start?alt=json", body, &op)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+func (a *apiV1Alpha1) authorize(req AuthorizeRequest) (*Operation, error) {
+	// v1alpha1 returns an empty response rather than an operation
+	err := a.c.do("POST", endpointV1Alpha1Environment+":authorize?alt=json", newAuthorizeBody(req), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Operation{Done: true}, nil
+}
+
+//******************************************************************************************
+// Method: users.environments.publicKeys.create
+// https://cloud.google.com/shell/docs/reference/rest/v1alpha1/users.environments.publicKeys/create
+//******************************************************************************************
+
+func (a *apiV1Alpha1) addPublicKey(key string) (*Operation, error) {
+	pk, err := newAlphaPublicKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]alphaPublicKey{"key": pk}
+
+	err = a.c.do("POST", endpointV1Alpha1Environment+"/publicKeys?alt=json", body, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Operation{Done: true}, nil
+}
+
+//******************************************************************************************
+// Method: users.environments.publicKeys.delete
+// https://cloud.google.com/shell/docs/reference/rest/v1alpha1/users.environments.publicKeys/delete
+//******************************************************************************************
+
+func (a *apiV1Alpha1) removePublicKey(key string) (*Operation, error) {
+	pk, err := newAlphaPublicKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := a.environment()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range env.PublicKeys {
+		if k.Format == pk.Format && k.Key == pk.Key {
+			err = a.c.do("DELETE", endpointBase+APIVersionV1Alpha1+"/"+k.Name+"?alt=json", nil, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return &Operation{Done: true}, nil
+		}
+	}
+
+	return nil, ErrPublicKeyNotFound
+}
+
+func (a *apiV1Alpha1) getOperation(name string) (*Operation, error) {
+	return getOperation(a.c, name)
+}
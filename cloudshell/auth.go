@@ -0,0 +1,273 @@
+package cloudshell
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+//******************************************************************************************
+// SSH authentication
+//
+// Keys are taken from the SSH agent and from the private key file, in the
+// order given by Options.AuthOrder. All keys are offered in a single
+// "publickey" method because the SSH client does not retry a method that
+// has already failed.
+//******************************************************************************************
+
+// Authentication sources for Options.AuthOrder.
+const (
+	AuthAgent   = "agent"
+	AuthKeyFile = "keyfile"
+)
+
+// DefaultAuthOrder tries the key file registered with Cloud Shell first and
+// falls back to the keys of a running SSH agent.
+var DefaultAuthOrder = []string{AuthKeyFile, AuthAgent}
+
+// ErrAgentNotRunning is returned when the SSH agent is selected but
+// SSH_AUTH_SOCK is not set, or on Windows the agent pipe does not exist.
+var ErrAgentNotRunning = errors.New("cloudshell: SSH agent is not running")
+
+// ParseAuthOrder parses a comma separated list of authentication sources,
+// for example "agent,keyfile".
+func ParseAuthOrder(s string) ([]string, error) {
+	var order []string
+
+	for _, source := range strings.Split(s, ",") {
+		source = strings.TrimSpace(source)
+
+		switch source {
+		case AuthAgent, AuthKeyFile:
+			order = append(order, source)
+
+		case "":
+
+		default:
+			return nil, fmt.Errorf("cloudshell: unknown authentication source %q (%s, %s)", source, AuthAgent, AuthKeyFile)
+		}
+	}
+
+	if len(order) == 0 {
+		return nil, errors.New("cloudshell: no authentication source")
+	}
+
+	return order, nil
+}
+
+// PassphraseFunc returns the passphrase of an encrypted private key file.
+type PassphraseFunc func(file string) ([]byte, error)
+
+// PublicKeyFile loads an OpenSSH private key file as an SSH auth method.
+// Encrypted keys are not supported, use ReadPrivateKey.
+func PublicKeyFile(file string) (ssh.AuthMethod, error) {
+	key, _, err := readPrivateKey(file, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+// ReadPrivateKey reads an OpenSSH or PEM private key file. If the key is
+// encrypted, passphrase is called to decrypt it. Without a passphrase
+// function an encrypted key returns an *ssh.PassphraseMissingError.
+func ReadPrivateKey(file string, passphrase PassphraseFunc) (crypto.PrivateKey, error) {
+	key, _, err := readPrivateKey(file, passphrase)
+
+	return key, err
+}
+
+// readPrivateKey also returns the passphrase that decrypted the key, or nil.
+func readPrivateKey(file string, passphrase PassphraseFunc) (crypto.PrivateKey, []byte, error) {
+	buffer, err := ioutil.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrKeyNotFound, file)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ssh.ParseRawPrivateKey(buffer)
+
+	var missing *ssh.PassphraseMissingError
+
+	if errors.As(err, &missing) == false || passphrase == nil {
+		if err != nil {
+			return nil, nil, fmt.Errorf("cloudshell: %s: %w", file, err)
+		}
+
+		return key, nil, nil
+	}
+
+	secret, err := passphrase(file)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err = ssh.ParseRawPrivateKeyWithPassphrase(buffer, secret)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("cloudshell: %s: %w", file, err)
+	}
+
+	return key, secret, nil
+}
+
+// keyFileSigner returns the signer of the key file. Decrypted keys are
+// cached so that the passphrase is only requested once per Client.
+func (c *Client) keyFileSigner(file string) (ssh.Signer, error) {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+
+	if signer, ok := c.keys[file]; ok == true {
+		return signer, nil
+	}
+
+	key, _, err := readPrivateKey(file, c.opts.Passphrase)
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.keys == nil {
+		c.keys = make(map[string]ssh.Signer)
+	}
+
+	c.keys[file] = signer
+
+	return signer, nil
+}
+
+// Agent connects to the SSH agent at socket, or $SSH_AUTH_SOCK when socket
+// is empty. On Windows socket may be a named pipe, and defaults to the pipe
+// of the OpenSSH agent service. The caller closes the returned connection.
+func Agent(socket string) (agent.ExtendedAgent, io.Closer, error) {
+	if socket == "" {
+		socket = defaultAgentSocket()
+	}
+
+	if socket == "" {
+		return nil, nil, fmt.Errorf("%w (SSH_AUTH_SOCK is not set)", ErrAgentNotRunning)
+	}
+
+	conn, err := dialAgent(socket)
+
+	if errors.Is(err, ErrAgentNotRunning) {
+		return nil, nil, err
+	}
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("cloudshell: SSH agent: %w", err)
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
+
+// authMethods returns the auth methods for a connection and a function that
+// releases the SSH agent once the handshake is done.
+func (c *Client) authMethods() ([]ssh.AuthMethod, func(), error) {
+	done := func() {}
+
+	if len(c.opts.Signers) != 0 {
+		return []ssh.AuthMethod{ssh.PublicKeys(c.opts.Signers...)}, done, nil
+	}
+
+	order := c.opts.AuthOrder
+
+	if len(order) == 0 {
+		order = DefaultAuthOrder
+	}
+
+	var signers []ssh.Signer
+	var errs []error
+
+	for _, source := range order {
+		switch source {
+		case AuthKeyFile:
+			file, err := c.keyFile()
+
+			if err == nil {
+				var key ssh.Signer
+
+				key, err = c.keyFileSigner(file)
+
+				if err == nil {
+					signers = append(signers, key)
+				}
+			}
+
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+		case AuthAgent:
+			a, conn, err := Agent(c.opts.AgentSocket)
+
+			if err == nil {
+				var keys []ssh.Signer
+
+				keys, err = a.Signers()
+
+				if err == nil {
+					c.logf("SSH agent: %d key(s)", len(keys))
+					signers = append(signers, keys...)
+				}
+
+				prev := done
+
+				done = func() {
+					prev()
+					conn.Close()
+				}
+			}
+
+			if err != nil {
+				errs = append(errs, err)
+			}
+
+		default:
+			return nil, done, fmt.Errorf("cloudshell: unknown authentication source %q", source)
+		}
+	}
+
+	if len(signers) == 0 {
+		done()
+
+		if len(errs) == 0 {
+			return nil, done, errors.New("cloudshell: no SSH keys available")
+		}
+
+		// Report the first source, which is the one the user prefers
+		return nil, done, errs[0]
+	}
+
+	for _, err := range errs {
+		c.logf("SSH auth: %v", err)
+	}
+
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, done, nil
+}
@@ -0,0 +1,244 @@
+// Package cloudshell is a client library for Google Cloud Shell.
+//
+// The Client type talks to the Cloud Shell REST API to look up and start the
+// user's environment, and connects to the environment over SSH to execute
+// commands and to transfer files with SFTP.
+//
+// Cloud Shell does not accept service account credentials. The token source
+// passed to NewClient must return OAuth 2.0 User Credentials, or Application
+// Default Credentials when running on Compute Engine.
+package cloudshell
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kirinlabs/HttpRequest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/oauth2"
+)
+
+// Options configures a Client. The zero value is usable.
+type Options struct {
+	// Path to the OpenSSH private key used to connect to Cloud Shell.
+	// Defaults to ~/.ssh/google_compute_engine
+	KeyFile string
+
+	// Keys used to connect to Cloud Shell instead of KeyFile and the
+	// SSH agent.
+	Signers []ssh.Signer
+
+	// Order in which the SSH agent (AuthAgent) and the key file
+	// (AuthKeyFile) are tried. A source that is not listed is not used.
+	// Defaults to DefaultAuthOrder.
+	AuthOrder []string
+
+	// Path of the SSH agent socket. Defaults to $SSH_AUTH_SOCK. On
+	// Windows it may be a named pipe, and defaults to the pipe of the
+	// OpenSSH agent service, \\.\pipe\openssh-ssh-agent.
+	AgentSocket string
+
+	// Called for the passphrase when KeyFile is encrypted. The
+	// decrypted key is kept by the Client, so it is called once.
+	Passphrase PassphraseFunc
+
+	// Host key policy: HostKeyTOFU, HostKeyStrict or HostKeyInsecure.
+	// Defaults to HostKeyTOFU.
+	HostKeyPolicy string
+
+	// known_hosts file for Cloud Shell host keys.
+	// Defaults to ~/.ssh/cloudshell_known_hosts
+	KnownHostsFile string
+
+	// Cloud Shell API version, APIVersionV1 or APIVersionV1Alpha1.
+	// Defaults to APIVersionV1Alpha1.
+	APIVersion string
+
+	// Maximum time WaitUntilRunning waits for the environment to accept
+	// SSH connections. Defaults to DefaultWaitTimeout.
+	WaitTimeout time.Duration
+
+	// Called by WaitUntilRunning when the environment state changes,
+	// for example from DISABLED to STARTING.
+	OnStateChange func(from, to string)
+
+	// Called with a function that restores the local terminal when it is
+	// switched to raw mode for a pseudo-terminal, so that a program that
+	// exits on a signal can restore it. The function may be called more
+	// than once.
+	OnRawTerminal func(restore func())
+
+	// Optional debug logger. Nothing is logged when nil.
+	Logf func(format string, v ...interface{})
+}
+
+// Client is a Cloud Shell API and SSH client.
+type Client struct {
+	tokenSource oauth2.TokenSource
+	projectId   string
+	opts        Options
+	api         api
+
+	mu sync.Mutex
+
+	// Decrypted key files. keysMu is held while a key file is read so that
+	// concurrent connections request the passphrase only once.
+	keysMu sync.Mutex
+	keys   map[string]ssh.Signer
+}
+
+// NewClient returns a Client that authenticates with the token source and
+// bills API requests to the project ID (X-Goog-User-Project). It fails if
+// opts.APIVersion is not supported.
+func NewClient(ts oauth2.TokenSource, projectId string, opts *Options) (*Client, error) {
+	c := &Client{
+		tokenSource: ts,
+		projectId:   projectId,
+	}
+
+	if opts != nil {
+		c.opts = *opts
+	}
+
+	a, err := newAPI(c, c.opts.APIVersion)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.api = a
+
+	return c, nil
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	if c.opts.Logf != nil {
+		c.opts.Logf(format, v...)
+	}
+}
+
+// APIError is an error returned by the Cloud Shell API.
+type APIError struct {
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e *APIError) Error() string {
+	if e.Status != "" {
+		return fmt.Sprintf("cloudshell: %s (%d %s)", e.Message, e.Code, e.Status)
+	}
+
+	return fmt.Sprintf("cloudshell: %s (%d)", e.Message, e.Code)
+}
+
+//******************************************************************************************
+// HTTP helpers
+//******************************************************************************************
+
+func (c *Client) headers() (map[string]string, error) {
+	if c.tokenSource == nil {
+		return nil, errors.New("cloudshell: missing token source")
+	}
+
+	token, err := c.tokenSource.Token()
+
+	if err != nil {
+		return nil, fmt.Errorf("cloudshell: cannot get access token: %w", err)
+	}
+
+	hdrs := map[string]string{
+		"Authorization": "Bearer " + token.AccessToken,
+	}
+
+	if c.projectId != "" {
+		hdrs["X-Goog-User-Project"] = c.projectId
+	}
+
+	return hdrs, nil
+}
+
+// do sends a request to the API and unmarshals the JSON response into out.
+// method is GET, POST or DELETE. The body, if not empty, is sent as JSON.
+func (c *Client) do(method, endpoint string, body interface{}, out interface{}) error {
+	hdrs, err := c.headers()
+
+	if err != nil {
+		return err
+	}
+
+	req := HttpRequest.NewRequest()
+
+	var res *HttpRequest.Response
+
+	switch method {
+	case "GET":
+		req.SetHeaders(hdrs)
+		res, err = req.Get(endpoint)
+
+	case "POST":
+		content := ""
+
+		if body != nil {
+			b, err := json.Marshal(body)
+
+			if err != nil {
+				return err
+			}
+
+			content = string(b)
+			hdrs["Content-Type"] = "application/json"
+		}
+
+		req.SetHeaders(hdrs)
+		res, err = req.Post(endpoint, content)
+
+	case "DELETE":
+		req.SetHeaders(hdrs)
+		res, err = req.Delete(endpoint)
+
+	default:
+		return fmt.Errorf("cloudshell: unsupported method %s", method)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	data, err := res.Body()
+
+	if err != nil {
+		return err
+	}
+
+	c.logf("%s %s: %s", method, endpoint, string(data))
+
+	if res.StatusCode() >= 400 {
+		var status struct {
+			Error *APIError `json:"error"`
+		}
+
+		err = json.Unmarshal(data, &status)
+
+		if err != nil || status.Error == nil {
+			return fmt.Errorf("cloudshell: %s %s: HTTP status %d", method, endpoint, res.StatusCode())
+		}
+
+		return status.Error
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(data, out)
+
+	if err != nil {
+		return fmt.Errorf("cloudshell: cannot unmarshal JSON: %w", err)
+	}
+
+	return nil
+}
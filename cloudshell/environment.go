@@ -0,0 +1,151 @@
+package cloudshell
+
+import (
+	"context"
+	"time"
+)
+
+//******************************************************************************************
+// Cloud Shell State
+//
+// https://cloud.google.com/shell/docs/reference/rest/Shared.Types/State
+//
+// STATE_UNSPECIFIED	The environment's states is unknown.
+// DISABLED		The environment is not running and can't be connected to.
+//			Starting the environment will transition it to the STARTING state.
+// STARTING		The environment is being started but is not yet ready to accept
+//			connections.
+// RUNNING		The environment is running and ready to accept connections. It
+//			will automatically transition back to DISABLED after a period of
+//			inactivity or if another environment is started.
+//
+// The v1 API renames DISABLED to SUSPENDED and STARTING to PENDING, and adds
+// DELETING.
+//******************************************************************************************
+
+const (
+	StateUnspecified = "STATE_UNSPECIFIED"
+	StateDisabled    = "DISABLED"
+	StateStarting    = "STARTING"
+	StateRunning     = "RUNNING"
+
+	// v1 states
+	StateSuspended = "SUSPENDED"
+	StatePending   = "PENDING"
+	StateDeleting  = "DELETING"
+)
+
+//******************************************************************************************
+// https://cloud.google.com/shell/docs/reference/rest/Shared.Types/Environment
+// https://cloud.google.com/shell/docs/reference/rest/v1/users.environments
+//******************************************************************************************
+
+// Environment is a Cloud Shell environment resource.
+type Environment struct {
+	Name        string `json:"name"`
+	Id          string `json:"id"`
+	DockerImage string `json:"dockerImage"`
+	State       string `json:"state"`
+	SshUsername string `json:"sshUsername"`
+	SshHost     string `json:"sshHost"`
+	SshPort     int32  `json:"sshPort"`
+
+	// Host to access the environment from a web browser. v1 only.
+	WebHost string `json:"webHost,omitempty"`
+
+	// Public keys associated with the environment in OpenSSH
+	// authorized_keys format, for example "ssh-rsa AAAA...".
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+// StartRequest is the optional body of a start request.
+type StartRequest struct {
+	// The initial access token passed to the environment. If present and
+	// valid, gcloud in the environment is pre-authenticated.
+	AccessToken string `json:"accessToken,omitempty"`
+
+	// Public keys to add to the environment before it starts, in OpenSSH
+	// authorized_keys format.
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+// AuthorizeRequest is the body of an authorize request.
+type AuthorizeRequest struct {
+	AccessToken string
+	IdToken     string
+
+	// Expiry of the credentials. Optional.
+	ExpireTime time.Time
+}
+
+//******************************************************************************************
+// Method: users.environments.get
+//******************************************************************************************
+
+// GetEnvironment returns the user's default Cloud Shell environment.
+func (c *Client) GetEnvironment(ctx context.Context) (Environment, error) {
+	if err := ctx.Err(); err != nil {
+		return Environment{}, err
+	}
+
+	return c.api.getEnvironment()
+}
+
+//******************************************************************************************
+// Method: users.environments.start
+//******************************************************************************************
+
+// Start requests that the default environment be started and returns the
+// long-running start operation. req may be nil. Start does not wait for the
+// environment to be running, use WaitUntilRunning.
+func (c *Client) Start(ctx context.Context, req *StartRequest) (*Operation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.logf("Request users.environments.start")
+
+	return c.api.start(req)
+}
+
+//******************************************************************************************
+// Method: users.environments.authorize
+//******************************************************************************************
+
+// Authorize sends OAuth credentials to a running environment on behalf of
+// the user, so that gcloud in the environment is authenticated.
+func (c *Client) Authorize(ctx context.Context, req AuthorizeRequest) (*Operation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.api.authorize(req)
+}
+
+//******************************************************************************************
+// Method: users.environments.addPublicKey
+//******************************************************************************************
+
+// AddPublicKey adds a public key, in OpenSSH authorized_keys format, to the
+// environment.
+func (c *Client) AddPublicKey(ctx context.Context, key string) (*Operation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.api.addPublicKey(key)
+}
+
+//******************************************************************************************
+// Method: users.environments.removePublicKey
+//******************************************************************************************
+
+// RemovePublicKey removes a public key, in OpenSSH authorized_keys format,
+// from the environment.
+func (c *Client) RemovePublicKey(ctx context.Context, key string) (*Operation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.api.removePublicKey(key)
+}
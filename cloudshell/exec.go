@@ -0,0 +1,237 @@
+package cloudshell
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Command is a command to run on the environment with Run.
+type Command struct {
+	// Shell command line, run by the user's login shell. An empty
+	// command runs an interactive login shell. Use ShellQuote to build
+	// a command line from arguments.
+	Command string
+
+	// Environment variables in the form "KEY=value". They are sent with
+	// SSH env requests, and exported by the command line for variables
+	// the server does not accept.
+	Env []string
+
+	// Working directory of the command. Defaults to the home directory.
+	Dir string
+
+	// Allocate a pseudo-terminal. If Stdin is a terminal it is switched
+	// to raw mode while the command runs.
+	TTY bool
+
+	// Copied to the standard input of the command in the background,
+	// so that Run does not wait for Stdin to be closed. The standard
+	// input of the command is closed at the end of Stdin. Optional.
+	Stdin io.Reader
+
+	// Receive the standard output and standard error of the command
+	// as it runs. Discarded when nil.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run runs a command on the environment and waits for it to exit.
+//
+// If the command exits with a non-zero status the error is an
+// *ssh.ExitError. A command killed by a signal exits with 128 plus the
+// signal number, as in a shell. If ctx is done before the command exits, the
+// command is sent SIGKILL and the session is closed.
+func (c *Client) Run(ctx context.Context, env Environment, cmd *Command) error {
+	connection, err := c.Dial(ctx, env)
+
+	if err != nil {
+		return err
+	}
+
+	defer connection.Close()
+
+	session, err := connection.NewSession()
+
+	if err != nil {
+		return err
+	}
+
+	defer session.Close()
+
+	command, err := c.commandLine(cmd, session.Setenv)
+
+	if err != nil {
+		return err
+	}
+
+	//************************************************************
+	//
+	//************************************************************
+
+	if cmd.TTY == true {
+		restore, err := c.requestPty(session, cmd.Stdin, cmd.Stdout)
+
+		if err != nil {
+			return err
+		}
+
+		defer restore()
+	}
+
+	if cmd.Stdin != nil {
+		err = attachStdin(session, cmd.Stdin)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	session.Stdout = cmd.Stdout
+	session.Stderr = cmd.Stderr
+
+	if command == "" {
+		c.logf("Run Shell")
+
+		err = session.Shell()
+	} else {
+		c.logf("Run Command: %s", command)
+
+		err = session.Start(command)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		return err
+
+	case <-ctx.Done():
+		c.logf("Kill Command: %v", ctx.Err())
+
+		// Not every server supports signals. Closing the session
+		// also ends a command that reads or writes its streams.
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+
+		return ctx.Err()
+	}
+}
+
+// commandLine returns the command line that runs cmd. Environment variables
+// that setenv does not accept and the working directory are set by the
+// command line.
+func (c *Client) commandLine(cmd *Command, setenv func(name, value string) error) (string, error) {
+	command := cmd.Command
+
+	//************************************************************
+	// Environment and working directory. Most servers only accept
+	// a few variables such as LANG, so the others are exported by
+	// the command line.
+	//************************************************************
+
+	var prefix []string
+
+	for _, kv := range cmd.Env {
+		k, v, ok := strings.Cut(kv, "=")
+
+		if ok == false || validEnvName(k) == false {
+			return "", fmt.Errorf("cloudshell: invalid environment variable %q", kv)
+		}
+
+		if err := setenv(k, v); err != nil {
+			c.logf("Setenv %s not accepted, exporting it", k)
+
+			prefix = append(prefix, "export "+k+"="+ShellQuote(v)+";")
+		}
+	}
+
+	if cmd.Dir != "" {
+		prefix = append(prefix, "cd "+ShellQuote(cmd.Dir)+" &&")
+	}
+
+	if len(prefix) != 0 {
+		if command == "" {
+			command = "exec \"$SHELL\" -l"
+		}
+
+		command = strings.Join(prefix, " ") + " " + command
+	}
+
+	return command, nil
+}
+
+// Exec runs a command on the environment and returns its standard output and
+// standard error. If the command exits with a non-zero status the error is
+// an *ssh.ExitError.
+func (c *Client) Exec(ctx context.Context, env Environment, command string) ([]byte, []byte, error) {
+	var stdoutBuf bytes.Buffer
+	var stderrBuf bytes.Buffer
+
+	err := c.Run(ctx, env, &Command{
+		Command: command,
+		Stdout:  &stdoutBuf,
+		Stderr:  &stderrBuf,
+	})
+
+	return stdoutBuf.Bytes(), stderrBuf.Bytes(), err
+}
+
+// ShellQuote quotes arguments for a POSIX shell and joins them with spaces.
+// Arguments that only contain safe characters are not quoted.
+func ShellQuote(args ...string) string {
+	quoted := make([]string, len(args))
+
+	for x, arg := range args {
+		quoted[x] = shellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	safe := true
+
+	for _, r := range s {
+		if strings.ContainsRune("@%+=:,./-_", r) == false && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			safe = false
+			break
+		}
+	}
+
+	if safe == true {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func validEnvName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+
+	return true
+}
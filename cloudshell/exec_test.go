@@ -0,0 +1,191 @@
+package cloudshell
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"", "''"},
+		{"abc", "abc"},
+		{"a/b.c-d_e", "a/b.c-d_e"},
+		{"user@host:~/x", "'user@host:~/x'"},
+		{"KEY=value,100%+1", "KEY=value,100%+1"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"'", `''\'''`},
+		{"''", `''\'''\'''`},
+		{"$HOME", "'$HOME'"},
+		{"${x}$(id)`id`", "'${x}$(id)`id`'"},
+		{`"quoted"`, `'"quoted"'`},
+		{"back\\slash", "'back\\slash'"},
+		{"a\nb", "'a\nb'"},
+		{"*?[]", "'*?[]'"},
+		{"a;b&c|d>e<f", "'a;b&c|d>e<f'"},
+		{"~", "'~'"},
+		{"#", "'#'"},
+		{"-n", "-n"},
+		{"é", "'é'"},
+	}
+
+	for _, tt := range tests {
+		if got := shellQuote(tt.arg); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+
+	if got := ShellQuote("echo", "a b", "", "it's"); got != `echo 'a b' '' 'it'\''s'` {
+		t.Errorf("ShellQuote = %s", got)
+	}
+
+	if got := ShellQuote(); got != "" {
+		t.Errorf("ShellQuote() = %q", got)
+	}
+}
+
+// The quoted arguments reach a POSIX shell command unchanged.
+func TestShellQuoteShell(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+
+	args := []string{"", "a b", "it's", "''", "$HOME", "`id`", "a\nb", "a\\", "\t", "*", "-n", "é"}
+
+	out, err := exec.Command("sh", "-c", "printf '[%s]' "+ShellQuote(args...)).Output()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[" + strings.Join(args, "][") + "]"
+
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestValidEnvName(t *testing.T) {
+	for _, name := range []string{"A", "_", "LANG", "lc_all", "_X1", "PATH_2"} {
+		if validEnvName(name) == false {
+			t.Errorf("validEnvName(%q) = false", name)
+		}
+	}
+
+	for _, name := range []string{"", "1A", "A-B", "A B", "A.B", "A;B", "$A", "A=", "É"} {
+		if validEnvName(name) == true {
+			t.Errorf("validEnvName(%q) = true", name)
+		}
+	}
+}
+
+// setenvOnly returns a setenv function that accepts the names and records the
+// variables it is called with.
+func setenvOnly(set *[]string, names ...string) func(string, string) error {
+	return func(name, value string) error {
+		*set = append(*set, name+"="+value)
+
+		for _, n := range names {
+			if n == name {
+				return nil
+			}
+		}
+
+		return errors.New("not accepted")
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     Command
+		want    string
+		wantSet []string
+	}{
+		{
+			name: "command",
+			cmd:  Command{Command: "ls -l"},
+			want: "ls -l",
+		},
+		{
+			name: "shell",
+			cmd:  Command{},
+			want: "",
+		},
+		{
+			name:    "accepted variable",
+			cmd:     Command{Command: "ls", Env: []string{"LANG=C"}},
+			want:    "ls",
+			wantSet: []string{"LANG=C"},
+		},
+		{
+			name:    "exported variables",
+			cmd:     Command{Command: "ls", Env: []string{"LANG=C", "A=it's $HOME", "B=", "C=a=b\nc"}},
+			want:    `export A='it'\''s $HOME'; export B=''; export C='a=b` + "\n" + `c'; ls`,
+			wantSet: []string{"LANG=C", "A=it's $HOME", "B=", "C=a=b\nc"},
+		},
+		{
+			name: "dir",
+			cmd:  Command{Command: "make all", Dir: "/tmp/my dir"},
+			want: "cd '/tmp/my dir' && make all",
+		},
+		{
+			name:    "dir and variables",
+			cmd:     Command{Command: "make", Dir: "src", Env: []string{"X=1", "LANG=C"}},
+			want:    "export X=1; cd src && make",
+			wantSet: []string{"X=1", "LANG=C"},
+		},
+		{
+			name: "dir of a shell",
+			cmd:  Command{Dir: "~/it's"},
+			want: `cd '~/it'\''s' && exec "$SHELL" -l`,
+		},
+		{
+			name:    "variable of a shell",
+			cmd:     Command{Env: []string{"X=1"}},
+			want:    `export X=1; exec "$SHELL" -l`,
+			wantSet: []string{"X=1"},
+		},
+	}
+
+	c := &Client{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var set []string
+
+			got, err := c.commandLine(&tt.cmd, setenvOnly(&set, "LANG"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("command line:\n%s\nwant:\n%s", got, tt.want)
+			}
+
+			if strings.Join(set, ",") != strings.Join(tt.wantSet, ",") {
+				t.Errorf("setenv %q, want %q", set, tt.wantSet)
+			}
+		})
+	}
+}
+
+func TestCommandLineInvalidEnv(t *testing.T) {
+	c := &Client{}
+
+	for _, kv := range []string{"NOVALUE", "=x", "1A=x", "A-B=x", "A B=x", "A;rm -rf ~;B=x", "$(id)=x"} {
+		var set []string
+
+		cmd := &Command{Command: "ls", Env: []string{"LANG=C", kv}}
+
+		if got, err := c.commandLine(cmd, setenvOnly(&set)); err == nil {
+			t.Errorf("Env %q: no error, command line %s", kv, got)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cloudshell
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+)
+
+//******************************************************************************************
+// File filters
+//
+// Patterns use the syntax of .gitignore files: "*", "?" and "[...]" match
+// within a name and "**" matches any number of directories. A pattern
+// without a "/" matches a name at any depth, a pattern with a "/" is
+// relative to the directory of the ignore file (or the root of the sync for
+// command line patterns), and a trailing "/" matches only directories.
+//
+// Include and exclude patterns are checked first, in the order they were
+// added, and the first match decides. Otherwise the rules of the ignore
+// files apply, where the last match decides and "!" negates a pattern.
+//******************************************************************************************
+
+// Filter selects the files of a sync.
+type Filter struct {
+	// Names of the ignore files that are read in each directory
+	ignoreFiles []string
+
+	rules  []filterRule
+	ignore []filterRule
+}
+
+type filterRule struct {
+	// Directory of the ignore file, relative to the root, or ""
+	base string
+
+	pattern string
+	negate  bool
+	dirOnly bool
+
+	// The pattern contains a "/" and matches the whole path
+	anchored bool
+}
+
+// NewFilter returns a Filter that reads the ignore files with the given
+// names, for example ".gitignore", in each directory of the source.
+func NewFilter(ignoreFiles ...string) *Filter {
+	return &Filter{ignoreFiles: ignoreFiles}
+}
+
+// Include adds a pattern of files to copy even if a later pattern or an
+// ignore file excludes them.
+func (f *Filter) Include(pattern string) error {
+	return f.add(pattern, true)
+}
+
+// Exclude adds a pattern of files that are not copied, and not deleted
+// from the destination.
+func (f *Filter) Exclude(pattern string) error {
+	return f.add(pattern, false)
+}
+
+func (f *Filter) add(pattern string, include bool) error {
+	rule, ok := parseFilterRule("", pattern)
+
+	if ok == false {
+		return nil
+	}
+
+	if _, err := path.Match(rule.pattern, ""); err != nil {
+		return err
+	}
+
+	rule.negate = include
+
+	f.rules = append(f.rules, rule)
+
+	return nil
+}
+
+// IgnoreFiles returns the names of the ignore files.
+func (f *Filter) IgnoreFiles() []string {
+	return f.ignoreFiles
+}
+
+// addIgnoreFile adds the rules of an ignore file in the directory dir,
+// relative to the root.
+func (f *Filter) addIgnoreFile(dir string, data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		rule, ok := parseFilterRule(dir, scanner.Text())
+
+		if ok == false {
+			continue
+		}
+
+		// Skip invalid patterns like git does
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			continue
+		}
+
+		f.ignore = append(f.ignore, rule)
+	}
+}
+
+func parseFilterRule(base, line string) (filterRule, bool) {
+	rule := filterRule{base: base}
+
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped
+	if strings.HasSuffix(line, "\\ ") == false {
+		line = strings.TrimRight(line, " ")
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return rule, false
+	}
+
+	rule.pattern = line
+
+	return rule, true
+}
+
+// Excluded reports whether the file or directory rel, a "/" separated path
+// relative to the root, is excluded.
+func (f *Filter) Excluded(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+
+	for _, rule := range f.rules {
+		if rule.match(rel, isDir) {
+			return rule.negate == false
+		}
+	}
+
+	excluded := false
+
+	for _, rule := range f.ignore {
+		if rule.match(rel, isDir) {
+			excluded = rule.negate == false
+		}
+	}
+
+	return excluded
+}
+
+func (r filterRule) match(rel string, isDir bool) bool {
+	if r.dirOnly == true && isDir == false {
+		return false
+	}
+
+	if r.base != "" {
+		if strings.HasPrefix(rel, r.base+"/") == false {
+			return false
+		}
+
+		rel = rel[len(r.base)+1:]
+	}
+
+	if r.anchored == false {
+		matched, _ := path.Match(r.pattern, path.Base(rel))
+
+		return matched
+	}
+
+	return matchSegments(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments, where a "**" segment matches any
+// number of segments. A trailing "**" matches at least one, so "dir/**"
+// matches what is inside dir but not dir itself, like git.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) != 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(name) != 0
+			}
+
+			for x := 0; x <= len(name); x++ {
+				if matchSegments(pattern[1:], name[x:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		matched, _ := path.Match(pattern[0], name[0])
+
+		if matched == false {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
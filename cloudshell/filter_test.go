@@ -0,0 +1,271 @@
+package cloudshell
+
+import (
+	"strings"
+	"testing"
+)
+
+type filterCase struct {
+	path     string
+	isDir    bool
+	excluded bool
+}
+
+func checkFilter(t *testing.T, f *Filter, cases []filterCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		if got := f.Excluded(c.path, c.isDir); got != c.excluded {
+			t.Errorf("Excluded(%q, %v) = %v, want %v", c.path, c.isDir, got, c.excluded)
+		}
+	}
+}
+
+// newIgnoreFilter returns a Filter with ignore files, given as pairs of a
+// directory relative to the root and the contents, in the order a sync
+// reads them.
+func newIgnoreFilter(files ...string) *Filter {
+	f := NewFilter(".gitignore")
+
+	for x := 0; x < len(files); x += 2 {
+		f.addIgnoreFile(files[x], []byte(files[x+1]))
+	}
+
+	return f
+}
+
+func TestFilterIgnoreFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore string
+		cases  []filterCase
+	}{
+		{
+			name:   "name at any depth",
+			ignore: "*.log\n",
+			cases: []filterCase{
+				{"a.log", false, true},
+				{"dir/b.log", false, true},
+				{"dir/sub/c.log", false, true},
+				{"a.txt", false, false},
+				{"log", false, false},
+			},
+		},
+		{
+			name:   "negation, last match wins",
+			ignore: "*.log\n!keep.log\n",
+			cases: []filterCase{
+				{"a.log", false, true},
+				{"keep.log", false, false},
+				{"dir/keep.log", false, false},
+			},
+		},
+		{
+			name:   "negation before the pattern has no effect",
+			ignore: "!keep.log\n*.log\n",
+			cases: []filterCase{
+				{"keep.log", false, true},
+			},
+		},
+		{
+			name:   "leading slash anchors to the root",
+			ignore: "/build\n",
+			cases: []filterCase{
+				{"build", true, true},
+				{"build", false, true},
+				{"src/build", true, false},
+			},
+		},
+		{
+			name:   "slash in the middle anchors to the root",
+			ignore: "docs/*.md\n",
+			cases: []filterCase{
+				{"docs/a.md", false, true},
+				{"docs/sub/a.md", false, false},
+				{"x/docs/a.md", false, false},
+				{"a.md", false, false},
+			},
+		},
+		{
+			name:   "trailing slash matches only directories",
+			ignore: "out/\n",
+			cases: []filterCase{
+				{"out", true, true},
+				{"src/out", true, true},
+				{"out", false, false},
+				{"src/out", false, false},
+			},
+		},
+		{
+			name:   "leading **",
+			ignore: "**/tmp\n",
+			cases: []filterCase{
+				{"tmp", true, true},
+				{"a/tmp", true, true},
+				{"a/b/tmp", false, true},
+				{"a/tmp2", false, false},
+			},
+		},
+		{
+			name:   "** in the middle",
+			ignore: "a/**/b\n",
+			cases: []filterCase{
+				{"a/b", false, true},
+				{"a/x/b", false, true},
+				{"a/x/y/b", false, true},
+				{"x/a/b", false, false},
+				{"a/b/c", false, false},
+			},
+		},
+		{
+			name:   "trailing ** matches inside the directory",
+			ignore: "logs/**\n",
+			cases: []filterCase{
+				{"logs/a", false, true},
+				{"logs/a/b", false, true},
+				{"logs", true, false},
+				{"logsx/a", false, false},
+			},
+		},
+		{
+			name:   "comments, blank lines, escapes and trailing spaces",
+			ignore: "# comment\n\n\\#hash\n\\!bang\nspace   \r\n",
+			cases: []filterCase{
+				{"# comment", false, false},
+				{"#hash", false, true},
+				{"!bang", false, true},
+				{"space", false, true},
+				{"space   ", false, false},
+			},
+		},
+		{
+			name:   "escaped trailing space is kept",
+			ignore: "space\\ \n",
+			cases: []filterCase{
+				{"space ", false, true},
+				{"space", false, false},
+			},
+		},
+		{
+			name:   "invalid patterns are skipped",
+			ignore: "[\n*.tmp\n",
+			cases: []filterCase{
+				{"[", false, false},
+				{"a.tmp", false, true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkFilter(t, newIgnoreFilter("", tt.ignore), tt.cases)
+		})
+	}
+}
+
+func TestFilterIgnoreFileBase(t *testing.T) {
+	f := newIgnoreFilter(
+		"", "*.bak\n",
+		"sub", "*.tmp\n/only\n!keep.bak\n",
+		"sub/dir", "docs/*.md\n",
+	)
+
+	checkFilter(t, f, []filterCase{
+		// Unanchored rules apply below their directory only
+		{"sub/a.tmp", false, true},
+		{"sub/x/a.tmp", false, true},
+		{"a.tmp", false, false},
+		{"other/a.tmp", false, false},
+		{"subx/a.tmp", false, false},
+
+		// Anchored rules are relative to their directory
+		{"sub/only", false, true},
+		{"sub/x/only", false, false},
+		{"only", false, false},
+		{"sub/dir/docs/a.md", false, true},
+		{"sub/docs/a.md", false, false},
+		{"docs/a.md", false, false},
+
+		// A deeper ignore file negates a rule of the root
+		{"a.bak", false, true},
+		{"keep.bak", false, true},
+		{"sub/keep.bak", false, false},
+		{"sub/x/keep.bak", false, false},
+		{"sub/other.bak", false, true},
+	})
+}
+
+func TestFilterIncludeExclude(t *testing.T) {
+	f := newIgnoreFilter("", "*.log\n!debug.tmp\n")
+
+	for _, p := range []string{"important.log", "/build/"} {
+		if err := f.Include(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{"*.tmp", "build", "vendor/"} {
+		if err := f.Exclude(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkFilter(t, f, []filterCase{
+		// Include overrides the ignore file
+		{"important.log", false, false},
+		{"dir/important.log", false, false},
+		{"other.log", false, true},
+
+		// Exclude overrides a negation of the ignore file
+		{"debug.tmp", false, true},
+
+		// The first of the command line patterns wins
+		{"build", true, false},
+		{"build", false, true},
+		{"src/build", true, true},
+
+		{"vendor", true, true},
+		{"vendor", false, false},
+		{"main.go", false, false},
+	})
+}
+
+func TestFilterAddInvalid(t *testing.T) {
+	f := NewFilter()
+
+	if err := f.Exclude("["); err == nil {
+		t.Error("Exclude(\"[\"): no error")
+	}
+
+	if err := f.Include("a[b"); err == nil {
+		t.Error("Include(\"a[b\"): no error")
+	}
+
+	// Empty patterns and comments are ignored
+	for _, p := range []string{"", "#", "/"} {
+		if err := f.Exclude(p); err != nil {
+			t.Errorf("Exclude(%q): %v", p, err)
+		}
+	}
+
+	checkFilter(t, f, []filterCase{
+		{"a", false, false},
+		{"#", false, false},
+	})
+}
+
+func TestFilterNil(t *testing.T) {
+	var f *Filter
+
+	if f.Excluded("a", false) {
+		t.Error("nil Filter excludes a file")
+	}
+}
+
+func TestFilterIgnoreFiles(t *testing.T) {
+	f := NewFilter(".gitignore", ".cloudshellignore")
+
+	if got := strings.Join(f.IgnoreFiles(), ","); got != ".gitignore,.cloudshellignore" {
+		t.Errorf("IgnoreFiles() = %s", got)
+	}
+}
@@ -0,0 +1,586 @@
+package cloudshell
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+//******************************************************************************************
+// Host key verification
+//
+// The SSH host and port of a Cloud Shell environment change every time it
+// starts, so host keys are stored in a known_hosts file under an alias made
+// from the environment ID and SSH username instead of under SshHost:SshPort.
+//******************************************************************************************
+
+// Host key policies for Options.HostKeyPolicy.
+const (
+	// Trust and remember the host key on first use, refuse a changed key.
+	HostKeyTOFU = "tofu"
+
+	// Refuse host keys that are not in the known_hosts file.
+	HostKeyStrict = "strict"
+
+	// Accept any host key. Not recommended.
+	HostKeyInsecure = "insecure"
+)
+
+// ErrHostKeyUnknown is returned in strict mode when the environment's host
+// key is not in the known_hosts file.
+var ErrHostKeyUnknown = errors.New("cloudshell: host key is not known")
+
+// ErrHostKeyMismatch is returned when the environment's host key does not
+// match the key in the known_hosts file.
+var ErrHostKeyMismatch = errors.New("cloudshell: host key does not match the known host key")
+
+// DefaultKnownHostsFile returns ~/.ssh/cloudshell_known_hosts
+func DefaultKnownHostsFile() (string, error) {
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ssh", "cloudshell_known_hosts"), nil
+}
+
+// HostKeyAlias returns the name under which the host key of the
+// environment is stored in the known_hosts file.
+func HostKeyAlias(env Environment) string {
+	id := env.Id
+
+	if id == "" {
+		id = strings.ReplaceAll(env.Name, "/", ".")
+	}
+
+	return "cloudshell-" + id + "." + env.SshUsername
+}
+
+func (c *Client) knownHostsFile() (string, error) {
+	if c.opts.KnownHostsFile != "" {
+		return c.opts.KnownHostsFile, nil
+	}
+
+	return DefaultKnownHostsFile()
+}
+
+func (c *Client) hostKeyCallback(env Environment) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return c.checkHostKey(env, remote, key)
+	}
+}
+
+func (c *Client) checkHostKey(env Environment, remote net.Addr, key ssh.PublicKey) error {
+	policy := c.opts.HostKeyPolicy
+
+	switch policy {
+	case "":
+		policy = HostKeyTOFU
+
+	case HostKeyTOFU, HostKeyStrict:
+
+	case HostKeyInsecure:
+		return nil
+
+	default:
+		return errors.New("cloudshell: unknown host key policy: " + policy)
+	}
+
+	file, err := c.knownHostsFile()
+
+	if err != nil {
+		return err
+	}
+
+	alias := HostKeyAlias(env)
+
+	// Serialize with adding keys when connections are opened in parallel
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = checkKnownHost(file, alias, remote, key)
+
+	var keyErr *knownhosts.KeyError
+
+	if err == nil || errors.As(err, &keyErr) == false {
+		return err
+	}
+
+	if len(keyErr.Want) != 0 {
+		return fmt.Errorf("%w: %s presented %s, known key is %s in %s:%d (run \"cloudshell hostkeys reset\" if the environment was recreated)",
+			ErrHostKeyMismatch, alias, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(keyErr.Want[0].Key), keyErr.Want[0].Filename, keyErr.Want[0].Line)
+	}
+
+	if policy == HostKeyStrict {
+		return fmt.Errorf("%w: %s %s", ErrHostKeyUnknown, alias, ssh.FingerprintSHA256(key))
+	}
+
+	c.logf("Adding host key %s %s to %s", alias, ssh.FingerprintSHA256(key), file)
+
+	return addKnownHost(file, alias, key)
+}
+
+func checkKnownHost(file, alias string, remote net.Addr, key ssh.PublicKey) error {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+
+	cb, err := knownhosts.New(file)
+
+	if err != nil {
+		return err
+	}
+
+	return cb(alias+":22", remote, key)
+}
+
+func addKnownHost(file, alias string, key ssh.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(knownhosts.Line([]string{alias}, key) + "\n")
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// KnownHost is an entry of the known_hosts file.
+type KnownHost struct {
+	Hosts []string
+	Key   ssh.PublicKey
+}
+
+// KnownHosts returns the entries of a known_hosts file. A missing file has
+// no entries.
+func KnownHosts(file string) ([]KnownHost, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []KnownHost
+
+	for len(data) != 0 {
+		_, h, key, _, rest, err := ssh.ParseKnownHosts(data)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		hosts = append(hosts, KnownHost{Hosts: h, Key: key})
+		data = rest
+	}
+
+	return hosts, nil
+}
+
+// RemoveKnownHost removes the entries for alias from a known_hosts file, or
+// all entries when alias is empty, and returns the number removed.
+func RemoveKnownHost(file, alias string) (int, error) {
+	data, err := ioutil.ReadFile(file)
+
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	var out bytes.Buffer
+	removed := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+
+		if len(fields) >= 2 && strings.HasPrefix(fields[0], "#") == false {
+			match := alias == ""
+
+			for _, host := range strings.Split(fields[0], ",") {
+				if host == alias {
+					match = true
+				}
+			}
+
+			if match == true {
+				removed++
+				continue
+			}
+		}
+
+		out.WriteString(line + "\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return removed, ioutil.WriteFile(file, out.Bytes(), 0600)
+}
+
+// KnownHostKey returns the known host key of the environment, or nil.
+func (c *Client) KnownHostKey(env Environment) (ssh.PublicKey, error) {
+	file, err := c.knownHostsFile()
+
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := KnownHosts(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	alias := HostKeyAlias(env)
+
+	for _, h := range hosts {
+		for _, host := range h.Hosts {
+			if host == alias {
+				return h.Key, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// hostKeysRequest is the global request in which an OpenSSH server announces
+// all of its host keys after authentication.
+const hostKeysRequest = "hostkeys-00@openssh.com"
+
+// hostKeysProveRequest asks the server to sign the session ID with each of
+// the announced keys, to prove that it holds them.
+const hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+
+// hostKeysWait is how long HostKeys waits for the announcement after the
+// reply to a request that was sent after it.
+var hostKeysWait = 500 * time.Millisecond
+
+// HostKeys connects to the environment and returns all of its host keys, for
+// clients like WinSCP that may negotiate a different key type. The key of
+// the connection is verified with the known_hosts file. The other keys are
+// announced by the server over the verified connection, and only the keys
+// that the server proves to hold are returned and, with the TOFU policy,
+// added to the known_hosts file.
+func (c *Client) HostKeys(ctx context.Context, env Environment) ([]ssh.PublicKey, error) {
+	auth, done, err := c.authMethods()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer done()
+
+	var verified ssh.PublicKey
+
+	config := c.sshConfig(env, auth)
+
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		verified = key
+
+		return c.checkHostKey(env, remote, key)
+	}
+
+	sshConn, chans, reqs, err := c.handshake(ctx, env, config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer sshConn.Close()
+
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "no channels")
+		}
+	}()
+
+	announcement := make(chan []byte, 1)
+
+	go func() {
+		for req := range reqs {
+			if req.Type == hostKeysRequest {
+				select {
+				case announcement <- req.Payload:
+				default:
+				}
+			}
+
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+
+	//************************************************************
+	// The server sends the announcement right after
+	// authentication, so it arrives before the reply to a
+	// request sent now. A server that does not announce its keys
+	// is waited for a short time after the reply.
+	//************************************************************
+
+	replied := make(chan error, 1)
+
+	go func() {
+		_, _, err := sshConn.SendRequest("keepalive@openssh.com", true, nil)
+		replied <- err
+	}()
+
+	var payload []byte
+
+	select {
+	case payload = <-announcement:
+
+	case err = <-replied:
+		if err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(hostKeysWait)
+		defer timer.Stop()
+
+		select {
+		case payload = <-announcement:
+		case <-timer.C:
+			c.logf("Host keys: no %s from the server", hostKeysRequest)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	proven, err := c.proveHostKeys(sshConn, verified, parseHostKeys(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.addHostKeys(env, verified, proven)
+}
+
+// proveHostKeys asks the server to prove that it holds the announced keys
+// other than the verified key, and returns the keys that it proves.
+func (c *Client) proveHostKeys(conn ssh.Conn, verified ssh.PublicKey, announced []ssh.PublicKey) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	var payload []byte
+
+	for _, key := range announced {
+		if bytes.Equal(key.Marshal(), verified.Marshal()) {
+			continue
+		}
+
+		keys = append(keys, key)
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{key.Marshal()})...)
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	ok, reply, err := conn.SendRequest(hostKeysProveRequest, true, payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ok == false {
+		c.logf("Host keys: %s refused", hostKeysProveRequest)
+		return nil, nil
+	}
+
+	return c.checkHostKeyProofs(conn.SessionID(), keys, reply), nil
+}
+
+// checkHostKeyProofs checks the reply to hostkeys-prove-00@openssh.com, a
+// signature by each key of the request name, the session ID and the key,
+// and returns the keys with a valid signature.
+func (c *Client) checkHostKeyProofs(sessionID []byte, keys []ssh.PublicKey, reply []byte) []ssh.PublicKey {
+	sigs := parseSSHStrings(reply)
+
+	var proven []ssh.PublicKey
+
+	for x, key := range keys {
+		if x >= len(sigs) {
+			c.logf("Host keys: no proof for %s", ssh.FingerprintSHA256(key))
+			continue
+		}
+
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{hostKeysProveRequest, sessionID, key.Marshal()})
+
+		var sig ssh.Signature
+
+		err := ssh.Unmarshal(sigs[x], &sig)
+
+		if err == nil {
+			err = key.Verify(data, &sig)
+		}
+
+		if err != nil {
+			c.logf("Host keys: invalid proof for %s: %v", ssh.FingerprintSHA256(key), err)
+			continue
+		}
+
+		proven = append(proven, key)
+	}
+
+	return proven
+}
+
+// addHostKeys returns the verified key and the proven keys, skipping proven
+// keys that conflict with a known key of the same type. New keys
+// are added to the known_hosts file with the TOFU policy.
+func (c *Client) addHostKeys(env Environment, verified ssh.PublicKey, proven []ssh.PublicKey) ([]ssh.PublicKey, error) {
+	keys := []ssh.PublicKey{verified}
+
+	policy := c.opts.HostKeyPolicy
+
+	if policy == HostKeyInsecure {
+		return append(keys, proven...), nil
+	}
+
+	file, err := c.knownHostsFile()
+
+	if err != nil {
+		return nil, err
+	}
+
+	alias := HostKeyAlias(env)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hosts, err := KnownHosts(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var known []ssh.PublicKey
+
+	for _, h := range hosts {
+		for _, host := range h.Hosts {
+			if host == alias {
+				known = append(known, h.Key)
+			}
+		}
+	}
+
+	for _, key := range proven {
+		if bytes.Equal(key.Marshal(), verified.Marshal()) {
+			continue
+		}
+
+		found := false
+		conflict := false
+
+		for _, k := range known {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				found = true
+			} else if k.Type() == key.Type() {
+				conflict = true
+			}
+		}
+
+		if conflict == true {
+			c.logf("Ignoring host key %s %s, a different %s key is known", alias, ssh.FingerprintSHA256(key), key.Type())
+			continue
+		}
+
+		keys = append(keys, key)
+
+		if found == true || policy == HostKeyStrict {
+			continue
+		}
+
+		c.logf("Adding host key %s %s to %s", alias, ssh.FingerprintSHA256(key), file)
+
+		err = addKnownHost(file, alias, key)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// parseHostKeys parses the payload of a hostkeys-00@openssh.com request, a
+// list of SSH strings that each hold a public key. Keys of unsupported types
+// are skipped.
+func parseHostKeys(payload []byte) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+
+	for _, blob := range parseSSHStrings(payload) {
+		if key, err := ssh.ParsePublicKey(blob); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// parseSSHStrings parses a list of SSH strings, each a length and bytes. A
+// truncated string ends the list.
+func parseSSHStrings(payload []byte) [][]byte {
+	var list [][]byte
+
+	for len(payload) >= 4 {
+		n := binary.BigEndian.Uint32(payload)
+		payload = payload[4:]
+
+		if uint64(n) > uint64(len(payload)) {
+			break
+		}
+
+		list = append(list, payload[:n])
+		payload = payload[n:]
+	}
+
+	return list
+}
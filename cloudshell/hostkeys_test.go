@@ -0,0 +1,401 @@
+package cloudshell
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T, keyType string) ssh.Signer {
+	t.Helper()
+
+	var key interface{}
+	var err error
+
+	switch keyType {
+	case "ed25519":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+
+	case "ecdsa":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+// marshalSSHStrings returns the SSH strings of a list.
+func marshalSSHStrings(list ...[]byte) []byte {
+	var b []byte
+
+	for _, s := range list {
+		b = append(b, ssh.Marshal(struct{ S []byte }{s})...)
+	}
+
+	return b
+}
+
+func sameKeys(a, b []ssh.PublicKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for x := range a {
+		if bytes.Equal(a[x].Marshal(), b[x].Marshal()) == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestParseHostKeys(t *testing.T) {
+	ed := newTestSigner(t, "ed25519").PublicKey()
+	ec := newTestSigner(t, "ecdsa").PublicKey()
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []ssh.PublicKey
+	}{
+		{"empty", nil, nil},
+		{"keys", marshalSSHStrings(ed.Marshal(), ec.Marshal()), []ssh.PublicKey{ed, ec}},
+		{"unsupported key is skipped", marshalSSHStrings(ed.Marshal(), []byte("\x00\x00\x00\x07ssh-foo"), ec.Marshal()), []ssh.PublicKey{ed, ec}},
+		{"truncated string ends the list", append(marshalSSHStrings(ed.Marshal()), 0, 0, 1, 0, 'x'), []ssh.PublicKey{ed}},
+		{"truncated length ends the list", append(marshalSSHStrings(ed.Marshal()), 0, 0), []ssh.PublicKey{ed}},
+	}
+
+	for _, tt := range tests {
+		if got := parseHostKeys(tt.payload); sameKeys(got, tt.want) == false {
+			t.Errorf("%s: parseHostKeys = %d keys, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+}
+
+func TestRemoveKnownHost(t *testing.T) {
+	ed := newTestSigner(t, "ed25519").PublicKey()
+	ec := newTestSigner(t, "ecdsa").PublicKey()
+
+	lines := []string{
+		"# comment cloudshell-a.user",
+		knownHostsLine("cloudshell-a.user", ed),
+		knownHostsLine("cloudshell-b.user", ed),
+		"",
+		knownHostsLine("cloudshell-a.user", ec),
+		knownHostsLine("other,cloudshell-a.user", ec),
+		knownHostsLine("cloudshell-a.user2", ec),
+	}
+
+	data := strings.Join(lines, "\n") + "\n"
+
+	tests := []struct {
+		alias   string
+		removed int
+		keep    []int
+	}{
+		{"cloudshell-a.user", 3, []int{0, 2, 3, 6}},
+		{"cloudshell-b.user", 1, []int{0, 1, 3, 4, 5, 6}},
+		{"other", 1, []int{0, 1, 2, 3, 4, 6}},
+		{"cloudshell-c.user", 0, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"", 5, []int{0, 3}},
+	}
+
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "known_hosts")
+
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		removed, err := RemoveKnownHost(file, tt.alias)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if removed != tt.removed {
+			t.Errorf("RemoveKnownHost(%q) = %d, want %d", tt.alias, removed, tt.removed)
+		}
+
+		var want string
+
+		for _, x := range tt.keep {
+			want += lines[x] + "\n"
+		}
+
+		got, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != want {
+			t.Errorf("RemoveKnownHost(%q):\n%s\nwant:\n%s", tt.alias, got, want)
+		}
+	}
+
+	removed, err := RemoveKnownHost(filepath.Join(t.TempDir(), "missing"), "")
+
+	if removed != 0 || err != nil {
+		t.Errorf("missing file: %d, %v", removed, err)
+	}
+}
+
+func knownHostsLine(hosts string, key ssh.PublicKey) string {
+	return hosts + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// testHostKeyServer is an SSH server with several host keys. After
+// authentication it announces announce, and it answers
+// hostkeys-prove-00@openssh.com by signing with the key returned by prover,
+// or refuses the request when prover is nil.
+type testHostKeyServer struct {
+	hostKeys []ssh.Signer
+	announce []ssh.PublicKey
+	prover   func(key ssh.PublicKey) ssh.Signer
+}
+
+func (s *testHostKeyServer) start(t *testing.T) Environment {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+
+	for _, key := range s.hostKeys {
+		config.AddHostKey(key)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn, config)
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+
+	return Environment{Id: "test", SshHost: addr.IP.String(), SshPort: int32(addr.Port), SshUsername: "user", State: "RUNNING"}
+}
+
+func (s *testHostKeyServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+
+	if err != nil {
+		return
+	}
+
+	defer sshConn.Close()
+
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "no channels")
+		}
+	}()
+
+	if s.announce != nil {
+		var blobs [][]byte
+
+		for _, key := range s.announce {
+			blobs = append(blobs, key.Marshal())
+		}
+
+		sshConn.SendRequest(hostKeysRequest, false, marshalSSHStrings(blobs...))
+	}
+
+	for req := range reqs {
+		if req.Type != hostKeysProveRequest || s.prover == nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var sigs [][]byte
+
+		for _, blob := range parseSSHStrings(req.Payload) {
+			key, err := ssh.ParsePublicKey(blob)
+
+			if err != nil {
+				req.Reply(false, nil)
+				return
+			}
+
+			data := ssh.Marshal(struct {
+				Request   string
+				SessionID []byte
+				Key       []byte
+			}{hostKeysProveRequest, sshConn.SessionID(), blob})
+
+			sig, err := s.prover(key).Sign(rand.Reader, data)
+
+			if err != nil {
+				req.Reply(false, nil)
+				return
+			}
+
+			sigs = append(sigs, ssh.Marshal(sig))
+		}
+
+		req.Reply(true, marshalSSHStrings(sigs...))
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	ed := newTestSigner(t, "ed25519")
+	ec := newTestSigner(t, "ecdsa")
+	forged := newTestSigner(t, "ecdsa")
+
+	announced := []ssh.PublicKey{ed.PublicKey(), ec.PublicKey()}
+
+	// Sign with the host key
+	prove := func(key ssh.PublicKey) ssh.Signer {
+		for _, s := range []ssh.Signer{ed, ec} {
+			if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+				return s
+			}
+		}
+
+		return forged
+	}
+
+	// A server that announces a key it does not hold
+	forge := func(key ssh.PublicKey) ssh.Signer {
+		if bytes.Equal(key.Marshal(), ec.PublicKey().Marshal()) {
+			return forged
+		}
+
+		return prove(key)
+	}
+
+	defer func(wait time.Duration) { hostKeysWait = wait }(hostKeysWait)
+
+	hostKeysWait = 50 * time.Millisecond
+
+	tests := []struct {
+		name   string
+		server testHostKeyServer
+		proven bool
+	}{
+		{"proven", testHostKeyServer{announce: announced, prover: prove}, true},
+		{"invalid proof", testHostKeyServer{announce: announced, prover: forge}, false},
+		{"prove refused", testHostKeyServer{announce: announced}, false},
+		{"no announcement", testHostKeyServer{prover: prove}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The connection only uses the Ed25519 key, so that the
+			// ECDSA key must be proven
+			tt.server.hostKeys = []ssh.Signer{ed}
+
+			env := tt.server.start(t)
+
+			file := filepath.Join(t.TempDir(), "known_hosts")
+
+			c := &Client{opts: Options{
+				Signers:        []ssh.Signer{newTestSigner(t, "ed25519")},
+				KnownHostsFile: file,
+			}}
+
+			want := []ssh.PublicKey{ed.PublicKey()}
+
+			if tt.proven {
+				want = append(want, ec.PublicKey())
+			}
+
+			// The second time the keys are known
+			for x := 0; x < 2; x++ {
+				keys, err := c.HostKeys(context.Background(), env)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if sameKeys(keys, want) == false {
+					t.Errorf("HostKeys returned %d keys, want %d", len(keys), len(want))
+				}
+
+				hosts, err := KnownHosts(file)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var known []ssh.PublicKey
+
+				for _, h := range hosts {
+					if len(h.Hosts) != 1 || h.Hosts[0] != HostKeyAlias(env) {
+						t.Errorf("known host %q", h.Hosts)
+					}
+
+					known = append(known, h.Key)
+				}
+
+				if sameKeys(known, want) == false {
+					t.Errorf("known_hosts has %d keys, want %d", len(known), len(want))
+				}
+			}
+		})
+	}
+}
+
+// Keys are not added with the strict policy, and the connection is refused
+// when its key is not known.
+func TestHostKeysStrict(t *testing.T) {
+	ed := newTestSigner(t, "ed25519")
+
+	server := testHostKeyServer{hostKeys: []ssh.Signer{ed}}
+
+	env := server.start(t)
+
+	file := filepath.Join(t.TempDir(), "known_hosts")
+
+	c := &Client{opts: Options{
+		Signers:        []ssh.Signer{newTestSigner(t, "ed25519")},
+		KnownHostsFile: file,
+		HostKeyPolicy:  HostKeyStrict,
+	}}
+
+	if _, err := c.HostKeys(context.Background(), env); err == nil || strings.Contains(err.Error(), ErrHostKeyUnknown.Error()) == false {
+		t.Errorf("err = %v, want %v", err, ErrHostKeyUnknown)
+	}
+
+	if _, err := os.Stat(file); os.IsNotExist(err) == false {
+		t.Errorf("known_hosts was written: %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package cloudshell
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Key types supported by GenerateKey.
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+)
+
+// rsaKeyBits is the size of generated RSA keys.
+const rsaKeyBits = 3072
+
+// KeyPair is an SSH key pair.
+type KeyPair struct {
+	// ed25519.PrivateKey or *rsa.PrivateKey
+	PrivateKey crypto.PrivateKey
+	Signer     ssh.Signer
+	Comment    string
+}
+
+// GenerateKey generates a new SSH key pair of the given type.
+func GenerateKey(keyType, comment string) (*KeyPair, error) {
+	var priv crypto.PrivateKey
+	var err error
+
+	switch keyType {
+	case KeyTypeEd25519:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+
+	case KeyTypeRSA:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+
+	default:
+		return nil, errors.New("cloudshell: unsupported key type: " + keyType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{PrivateKey: priv, Signer: signer, Comment: comment}, nil
+}
+
+// PublicKey returns the public half of the key pair.
+func (k *KeyPair) PublicKey() ssh.PublicKey {
+	return k.Signer.PublicKey()
+}
+
+// AuthorizedKey returns the public key in OpenSSH authorized_keys format.
+func (k *KeyPair) AuthorizedKey() string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.PublicKey())))
+
+	if k.Comment != "" {
+		line += " " + k.Comment
+	}
+
+	return line
+}
+
+// WriteFiles writes the private key to path in OpenSSH format, readable only
+// by the owner, and the public key to path.pub. The directory is created if
+// it does not exist.
+func (k *KeyPair) WriteFiles(path string) error {
+	return k.WriteFilesWithPassphrase(path, nil)
+}
+
+// WriteFilesWithPassphrase is like WriteFiles but encrypts the private key
+// with passphrase, unless it is empty.
+func (k *KeyPair) WriteFilesWithPassphrase(path string, passphrase []byte) error {
+	var block *pem.Block
+	var err error
+
+	if len(passphrase) == 0 {
+		block, err = ssh.MarshalPrivateKey(k.PrivateKey, k.Comment)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(k.PrivateKey, k.Comment, passphrase)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file so an existing key is never half written
+	tmp := path + ".tmp"
+
+	err = ioutil.WriteFile(tmp, pem.EncodeToMemory(block), 0600)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return ioutil.WriteFile(path+".pub", []byte(k.AuthorizedKey()+"\n"), 0644)
+}
+
+// ReadPublicKeyFile returns the public key of a key pair on disk in OpenSSH
+// authorized_keys format. It reads path.pub, or derives the public key from
+// the private key at path when path.pub does not exist.
+func ReadPublicKeyFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path + ".pub")
+
+	if err == nil {
+		return normalizeKey(string(data))
+	}
+
+	if os.IsNotExist(err) == false {
+		return "", err
+	}
+
+	data, err = ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return "", ErrKeyNotFound
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+
+	// The public key of an encrypted OpenSSH key is not encrypted
+	var missing *ssh.PassphraseMissingError
+
+	if errors.As(err, &missing) && missing.PublicKey != nil {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(missing.PublicKey))), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// HasPublicKey reports whether the environment has the public key.
+func (env Environment) HasPublicKey(key string) bool {
+	key, err := normalizeKey(key)
+
+	if err != nil {
+		return false
+	}
+
+	for _, k := range env.PublicKeys {
+		if n, err := normalizeKey(k); err == nil && n == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RegisterPublicKey adds the public key to the environment, unless it is
+// already present, and waits for the operation to complete. It reports
+// whether the key was added.
+func (c *Client) RegisterPublicKey(ctx context.Context, key string) (bool, error) {
+	env, err := c.GetEnvironment(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	if env.HasPublicKey(key) {
+		return false, nil
+	}
+
+	op, err := c.AddPublicKey(ctx, key)
+
+	if err != nil {
+		return false, err
+	}
+
+	err = c.WaitOperation(ctx, op)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UnregisterPublicKey removes the public key from the environment and waits
+// for the operation to complete.
+func (c *Client) UnregisterPublicKey(ctx context.Context, key string) error {
+	op, err := c.RemovePublicKey(ctx, key)
+
+	if err != nil {
+		return err
+	}
+
+	return c.WaitOperation(ctx, op)
+}
+
+// RotateKey replaces the key pair at path with a new key of keyType.
+//
+// The new public key is registered with the environment and an SSH login
+// with the new key is verified before the key files are replaced. The old
+// public key is removed from the environment last. If verification fails
+// the new public key is removed again and the key files are not changed.
+//
+// If the old private key is encrypted, the new one is encrypted with the
+// same passphrase, obtained from Options.Passphrase.
+func (c *Client) RotateKey(ctx context.Context, path, keyType, comment string) (*KeyPair, error) {
+	old, err := ReadPublicKeyFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Fails for an encrypted key without Options.Passphrase, so the key is
+	// never written unencrypted
+	_, passphrase, err := readPrivateKey(path, c.opts.Passphrase)
+
+	if err != nil {
+		return nil, fmt.Errorf("cloudshell: cannot read the key to rotate: %w", err)
+	}
+
+	key, err := GenerateKey(keyType, comment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.RegisterPublicKey(ctx, key.AuthorizedKey())
+
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := c.WaitUntilRunning(ctx)
+
+	if err == nil {
+		err = c.WaitForKey(ctx, env, key.Signer)
+	}
+
+	if err != nil {
+		c.UnregisterPublicKey(ctx, key.AuthorizedKey())
+		return nil, fmt.Errorf("cloudshell: cannot verify new key: %w", err)
+	}
+
+	err = key.WriteFilesWithPassphrase(path, passphrase)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Forget the signer of the old key
+	c.keysMu.Lock()
+	delete(c.keys, path)
+	c.keysMu.Unlock()
+
+	err = c.UnregisterPublicKey(ctx, old)
+
+	if err != nil && errors.Is(err, ErrPublicKeyNotFound) == false {
+		return key, fmt.Errorf("cloudshell: new key installed but the old key was not removed: %w", err)
+	}
+
+	return key, nil
+}
+
+// keyWaitTimeout bounds how long WaitForKey waits for a newly registered key
+// to be accepted by the environment.
+const keyWaitTimeout = 60 * time.Second
+
+// WaitForKey waits until the environment accepts an SSH login with signer.
+// A public key added to a running environment takes a few seconds to be
+// installed.
+func (c *Client) WaitForKey(ctx context.Context, env Environment, signer ssh.Signer) error {
+	ctx, cancel := context.WithTimeout(ctx, keyWaitTimeout)
+	defer cancel()
+
+	backoff := waitInitialBackoff
+
+	for {
+		err := c.VerifyKey(ctx, env, signer)
+
+		if err == nil {
+			return nil
+		}
+
+		c.logf("Key not accepted yet: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+
+		if backoff > waitMaxBackoff {
+			backoff = waitMaxBackoff
+		}
+	}
+}
+
+// EphemeralKey is an in-memory key pair that is registered with the
+// environment until Close is called. The private key is never written to
+// disk.
+type EphemeralKey struct {
+	*KeyPair
+
+	c *Client
+
+	// Held while the key is registered or removed
+	mu sync.Mutex
+
+	// The public key may have been added to the environment
+	added bool
+}
+
+// NewEphemeralKey generates an in-memory key pair and configures the client
+// to authenticate with it. Register adds the public key to the environment.
+// Set up the call to Close before calling Register, so that the key is also
+// removed when the program is interrupted during the registration.
+func (c *Client) NewEphemeralKey(keyType string) (*EphemeralKey, error) {
+	key, err := GenerateKey(keyType, "cloudshell-ephemeral")
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.opts.Signers = []ssh.Signer{key.Signer}
+
+	return &EphemeralKey{KeyPair: key, c: c}, nil
+}
+
+// Register adds the public key to the environment and waits for the
+// operation to complete.
+func (k *EphemeralKey) Register(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	op, err := k.c.AddPublicKey(ctx, k.AuthorizedKey())
+
+	if err != nil {
+		return err
+	}
+
+	// The operation may complete even if waiting for it fails
+	k.added = true
+
+	return k.c.WaitOperation(ctx, op)
+}
+
+// Close removes the public key from the environment. If Register is in
+// progress, Close waits for it to return first. Close does nothing if the
+// key was not added.
+func (k *EphemeralKey) Close(ctx context.Context) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.added == false {
+		return nil
+	}
+
+	err := k.c.UnregisterPublicKey(ctx, k.AuthorizedKey())
+
+	if err == nil {
+		k.added = false
+	}
+
+	return err
+}
@@ -0,0 +1,86 @@
+package cloudshell
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+//******************************************************************************************
+// https://cloud.google.com/shell/docs/reference/rest/Shared.Types/Operation
+//******************************************************************************************
+
+// Operation is a long-running operation returned by methods such as start.
+type Operation struct {
+	Name     string          `json:"name"`
+	Done     bool            `json:"done"`
+	Error    *APIError       `json:"error,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// StartMetadata is the metadata of the operation returned by Start. Its
+// State is one of STARTING, UNARMED, AWAITING_VM, AWAITING_COMPUTE_RESOURCES
+// or FINISHED.
+type StartMetadata struct {
+	State string `json:"state"`
+}
+
+// StartMetadata decodes the operation metadata of a start operation.
+func (op *Operation) StartMetadata() StartMetadata {
+	var md StartMetadata
+
+	if len(op.Metadata) != 0 {
+		json.Unmarshal(op.Metadata, &md)
+	}
+
+	return md
+}
+
+//******************************************************************************************
+// Method: operations.get
+// https://cloud.google.com/shell/docs/reference/rest/v1/operations/get
+//******************************************************************************************
+
+// GetOperation returns the latest state of a long-running operation.
+func (c *Client) GetOperation(ctx context.Context, name string) (*Operation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return c.api.getOperation(name)
+}
+
+// WaitOperation polls a long-running operation until it is done and returns
+// the operation error, if any.
+func (c *Client) WaitOperation(ctx context.Context, op *Operation) error {
+	backoff := waitInitialBackoff
+
+	for op.Done == false {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		next, err := c.GetOperation(ctx, op.Name)
+
+		if err != nil {
+			return err
+		}
+
+		op = next
+
+		backoff *= 2
+
+		if backoff > waitMaxBackoff {
+			backoff = waitMaxBackoff
+		}
+	}
+
+	if op.Error != nil {
+		return op.Error
+	}
+
+	return nil
+}
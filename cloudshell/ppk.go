@@ -0,0 +1,266 @@
+package cloudshell
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+//******************************************************************************************
+// PuTTY private key files (.ppk)
+//
+// https://the.earth.li/~sgtatham/putty/0.76/htmldoc/AppendixC.html
+//
+// Version 2 derives the AES-256-CBC key from the passphrase with SHA-1 and
+// protects the file with HMAC-SHA-1. Version 3 derives the cipher key, IV
+// and MAC key with Argon2id and protects the file with HMAC-SHA-256. PuTTY
+// before 0.75 only reads version 2.
+//******************************************************************************************
+
+// Argon2id parameters of version 3 files. These are PuTTY's defaults.
+const (
+	ppkArgon2Memory      = 8192
+	ppkArgon2Passes      = 13
+	ppkArgon2Parallelism = 1
+)
+
+// MarshalPPK encodes a private key as a PuTTY .ppk file of the given version
+// (2 or 3). The private key is encrypted when passphrase is not empty.
+// Supported keys are *rsa.PrivateKey, ed25519.PrivateKey and
+// *ecdsa.PrivateKey.
+func MarshalPPK(key crypto.PrivateKey, comment string, passphrase []byte, version int) ([]byte, error) {
+	salt := make([]byte, 16)
+
+	_, err := rand.Read(salt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalPPK(key, comment, passphrase, version, salt)
+}
+
+// marshalPPK is MarshalPPK with the Argon2 salt of version 3 files.
+func marshalPPK(key crypto.PrivateKey, comment string, passphrase []byte, version int, salt []byte) ([]byte, error) {
+	if version != 2 && version != 3 {
+		return nil, fmt.Errorf("cloudshell: unsupported PPK version %d", version)
+	}
+
+	// ssh.ParseRawPrivateKey returns OpenSSH ed25519 keys as pointers
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		key = *k
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pub := signer.PublicKey()
+	algorithm := pub.Type()
+
+	private, err := ppkPrivateBlob(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	encryption := "none"
+
+	if len(passphrase) != 0 {
+		encryption = "aes256-cbc"
+
+		// Pad to the cipher block size with the SHA-1 of the unpadded blob
+		if n := len(private) % aes.BlockSize; n != 0 {
+			sum := sha1.Sum(private)
+			private = append(private, sum[:aes.BlockSize-n]...)
+		}
+	}
+
+	//************************************************************
+	// Derive the cipher key, IV and MAC key
+	//************************************************************
+
+	var cipherKey, iv, macKey []byte
+	var newHash func() hash.Hash
+
+	if version == 2 {
+		newHash = sha1.New
+
+		mac := sha1.Sum(append([]byte("putty-private-key-file-mac-key"), passphrase...))
+		macKey = mac[:]
+
+		if encryption != "none" {
+			k0 := sha1.Sum(append([]byte{0, 0, 0, 0}, passphrase...))
+			k1 := sha1.Sum(append([]byte{0, 0, 0, 1}, passphrase...))
+
+			cipherKey = append(k0[:], k1[:]...)[:32]
+			iv = make([]byte, aes.BlockSize)
+		}
+	} else {
+		newHash = sha256.New
+
+		if encryption != "none" {
+			out := argon2.IDKey(passphrase, salt, ppkArgon2Passes, ppkArgon2Memory, ppkArgon2Parallelism, 32+aes.BlockSize+32)
+
+			cipherKey = out[:32]
+			iv = out[32 : 32+aes.BlockSize]
+			macKey = out[32+aes.BlockSize:]
+		}
+	}
+
+	//************************************************************
+	// The MAC covers the unencrypted, padded private blob
+	//************************************************************
+
+	macData := ssh.Marshal(struct {
+		Algorithm  string
+		Encryption string
+		Comment    string
+		Public     []byte
+		Private    []byte
+	}{algorithm, encryption, comment, pub.Marshal(), private})
+
+	mac := hmac.New(newHash, macKey)
+	mac.Write(macData)
+
+	if encryption != "none" {
+		block, err := aes.NewCipher(cipherKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		encrypted := make([]byte, len(private))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, private)
+		private = encrypted
+	}
+
+	//************************************************************
+	//
+	//************************************************************
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "PuTTY-User-Key-File-%d: %s\n", version, algorithm)
+	fmt.Fprintf(&b, "Encryption: %s\n", encryption)
+	fmt.Fprintf(&b, "Comment: %s\n", comment)
+	ppkWriteLines(&b, "Public-Lines", pub.Marshal())
+
+	if version == 3 && encryption != "none" {
+		fmt.Fprintf(&b, "Key-Derivation: Argon2id\n")
+		fmt.Fprintf(&b, "Argon2-Memory: %d\n", ppkArgon2Memory)
+		fmt.Fprintf(&b, "Argon2-Passes: %d\n", ppkArgon2Passes)
+		fmt.Fprintf(&b, "Argon2-Parallelism: %d\n", ppkArgon2Parallelism)
+		fmt.Fprintf(&b, "Argon2-Salt: %s\n", hex.EncodeToString(salt))
+	}
+
+	ppkWriteLines(&b, "Private-Lines", private)
+	fmt.Fprintf(&b, "Private-MAC: %s\n", hex.EncodeToString(mac.Sum(nil)))
+
+	return b.Bytes(), nil
+}
+
+func ppkWriteLines(b *bytes.Buffer, name string, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	var lines []string
+
+	for len(encoded) > 64 {
+		lines = append(lines, encoded[:64])
+		encoded = encoded[64:]
+	}
+
+	lines = append(lines, encoded)
+
+	fmt.Fprintf(b, "%s: %d\n", name, len(lines))
+
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+}
+
+// ppkPrivateBlob returns the private part of a key in PuTTY's format.
+func ppkPrivateBlob(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, errors.New("cloudshell: RSA keys with more than two primes are not supported")
+		}
+
+		k.Precompute()
+
+		return ssh.Marshal(struct {
+			D, P, Q, Iqmp *big.Int
+		}{k.D, k.Primes[0], k.Primes[1], k.Precomputed.Qinv}), nil
+
+	case ed25519.PrivateKey:
+		// The seed is an unsigned little-endian integer of minimal
+		// length, like PuTTY writes it
+		return ssh.Marshal(struct {
+			Seed []byte
+		}{bytes.TrimRight(k.Seed(), "\x00")}), nil
+
+	case *ecdsa.PrivateKey:
+		return ssh.Marshal(struct {
+			D *big.Int
+		}{k.D}), nil
+	}
+
+	return nil, fmt.Errorf("cloudshell: unsupported key type %T", key)
+}
+
+// ConvertToPPK converts the OpenSSH private key file src to a PuTTY .ppk
+// file dst. The comment is taken from src.pub when it exists.
+//
+// If src is encrypted, srcPassphrase is called to decrypt it, and when
+// passphrase is nil the .ppk file is encrypted with the same passphrase.
+func ConvertToPPK(src, dst string, srcPassphrase PassphraseFunc, passphrase []byte, version int) error {
+	key, secret, err := readPrivateKey(src, srcPassphrase)
+
+	if err != nil {
+		return err
+	}
+
+	if passphrase == nil {
+		passphrase = secret
+	}
+
+	comment := ""
+
+	if pub, err := ioutil.ReadFile(src + ".pub"); err == nil {
+		_, c, _, _, err := ssh.ParseAuthorizedKey(pub)
+
+		if err == nil {
+			comment = c
+		}
+	}
+
+	ppk, err := MarshalPPK(key, strings.TrimSpace(comment), passphrase, version)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, ppk, 0600)
+}
@@ -0,0 +1,183 @@
+package cloudshell
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The .ppk files in testdata are known answers for the OpenSSH keys next to
+// them, with the passphrase and Argon2 salt below. They were generated from
+// the PPK format specification with an implementation that shares no code
+// with this package (Argon2id checked against the RFC 9106 test vector,
+// AES-256-CBC from OpenSSL).
+const testPPKPassphrase = "correct horse battery staple"
+
+var testPPKSalt = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+var testPPKKeys = []string{"rsa", "ecdsa", "ed25519"}
+
+func TestMarshalPPK(t *testing.T) {
+	for _, name := range testPPKKeys {
+		key, err := ReadPrivateKey(filepath.Join("testdata", name), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		comment := name + "-test"
+
+		for _, version := range []int{2, 3} {
+			for _, encrypted := range []bool{false, true} {
+				file := testPPKFile(name, version, encrypted)
+
+				t.Run(file, func(t *testing.T) {
+					var passphrase []byte
+
+					if encrypted {
+						passphrase = []byte(testPPKPassphrase)
+					}
+
+					got, err := marshalPPK(key, comment, passphrase, version, testPPKSalt)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					want, err := ioutil.ReadFile(filepath.Join("testdata", file))
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if string(got) != string(want) {
+						t.Errorf("got:\n%s\nwant:\n%s", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+// The test key is chosen so that PuTTY writes its seed with 31 bytes.
+func TestMarshalPPKEd25519Seed(t *testing.T) {
+	key, err := ReadPrivateKey(filepath.Join("testdata", "ed25519"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed := (*key.(*ed25519.PrivateKey)).Seed()
+
+	if seed[len(seed)-1] != 0 {
+		t.Fatalf("the last byte of the seed is %#x, want 0", seed[len(seed)-1])
+	}
+}
+
+// Without a passphrase the output does not depend on the salt.
+func TestMarshalPPKUnencrypted(t *testing.T) {
+	for _, name := range testPPKKeys {
+		key, err := ReadPrivateKey(filepath.Join("testdata", name), nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, version := range []int{2, 3} {
+			got, err := MarshalPPK(key, name+"-test", nil, version)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := ioutil.ReadFile(filepath.Join("testdata", testPPKFile(name, version, false)))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != string(want) {
+				t.Errorf("%s version %d: got:\n%s\nwant:\n%s", name, version, got, want)
+			}
+		}
+	}
+}
+
+func TestMarshalPPKEncryptedSalt(t *testing.T) {
+	key, err := ReadPrivateKey(filepath.Join("testdata", "ed25519"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := MarshalPPK(key, "", []byte(testPPKPassphrase), 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := MarshalPPK(key, "", []byte(testPPKPassphrase), 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(a) == string(b) {
+		t.Error("version 3 files use the same Argon2 salt")
+	}
+}
+
+func TestMarshalPPKVersion(t *testing.T) {
+	key, err := ReadPrivateKey(filepath.Join("testdata", "ed25519"), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []int{0, 1, 4} {
+		if _, err := MarshalPPK(key, "", nil, version); err == nil {
+			t.Errorf("version %d: no error", version)
+		}
+	}
+}
+
+// ConvertToPPK takes the comment from the .pub file.
+func TestConvertToPPK(t *testing.T) {
+	for _, name := range testPPKKeys {
+		dst := filepath.Join(t.TempDir(), name+".ppk")
+
+		err := ConvertToPPK(filepath.Join("testdata", name), dst, nil, nil, 2)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ioutil.ReadFile(dst)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := ioutil.ReadFile(filepath.Join("testdata", testPPKFile(name, 2, false)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(got) != string(want) {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+}
+
+func testPPKFile(name string, version int, encrypted bool) string {
+	file := fmt.Sprintf("%s-v%d", name, version)
+
+	if encrypted {
+		file += "-encrypted"
+	}
+
+	return file + ".ppk"
+}
@@ -0,0 +1,60 @@
+package cloudshell
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+)
+
+// Shells that read a script from standard input with "-s".
+var scriptShells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"ash":  true,
+	"ksh":  true,
+	"mksh": true,
+	"zsh":  true,
+}
+
+// ScriptCommand returns the command line that runs a script sent on standard
+// input with the interpreter named by its "#!" line, passing args to the
+// script. Scripts without a "#!" line are run by sh.
+//
+// Shells are run as "interpreter -s -- args", other interpreters such as
+// python, perl, ruby and node as "interpreter - args".
+func ScriptCommand(script []byte, args ...string) string {
+	interpreter := []string{"sh"}
+
+	line, _, _ := bufio.NewReader(bytes.NewReader(script)).ReadLine()
+
+	if bytes.HasPrefix(line, []byte("#!")) {
+		if fields := strings.Fields(string(line[2:])); len(fields) != 0 {
+			interpreter = fields
+		}
+	}
+
+	// #!/usr/bin/env [-S] python3
+	if path.Base(interpreter[0]) == "env" {
+		interpreter = interpreter[1:]
+
+		for len(interpreter) != 0 && strings.HasPrefix(interpreter[0], "-") {
+			interpreter = interpreter[1:]
+		}
+
+		if len(interpreter) == 0 {
+			interpreter = []string{"sh"}
+		}
+	}
+
+	command := append([]string{}, interpreter...)
+
+	if scriptShells[path.Base(interpreter[0])] == true {
+		command = append(command, "-s", "--")
+	} else {
+		command = append(command, "-")
+	}
+
+	return ShellQuote(append(command, args...)...)
+}
@@ -0,0 +1,80 @@
+package cloudshell
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestScriptCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		args   []string
+		want   string
+	}{
+		{"empty script", "", nil, "sh -s --"},
+		{"no shebang", "echo hello\n", nil, "sh -s --"},
+		{"comment is not a shebang", "# !/bin/bash\necho hello\n", nil, "sh -s --"},
+		{"empty shebang", "#!\necho hello\n", nil, "sh -s --"},
+		{"bash", "#!/bin/bash\necho hello\n", nil, "/bin/bash -s --"},
+		{"shell with options", "#!/bin/bash -eu\n", nil, "/bin/bash -eu -s --"},
+		{"zsh", "#!/usr/bin/zsh\n", nil, "/usr/bin/zsh -s --"},
+		{"python", "#!/usr/bin/python3\nprint(1)\n", nil, "/usr/bin/python3 -"},
+		{"perl with options", "#!/usr/bin/perl -w\n", nil, "/usr/bin/perl -w -"},
+		{"env", "#!/usr/bin/env python3\n", nil, "python3 -"},
+		{"env shell", "#!/usr/bin/env bash\n", nil, "bash -s --"},
+		{"env -S", "#!/usr/bin/env -S node --flag\n", nil, "node --flag -"},
+		{"env -S shell", "#!/usr/bin/env -S bash -e\n", nil, "bash -e -s --"},
+		{"env without interpreter", "#!/usr/bin/env\n", nil, "sh -s --"},
+		{"space after #!", "#! /bin/sh\n", nil, "/bin/sh -s --"},
+		{"no newline", "#!/bin/bash", nil, "/bin/bash -s --"},
+		{"CRLF", "#!/bin/bash\r\necho hello\r\n", nil, "/bin/bash -s --"},
+		{"CRLF env", "#!/usr/bin/env python3\r\nprint(1)\r\n", nil, "python3 -"},
+		{"CRLF env -S", "#!/usr/bin/env -S node --flag\r\n", nil, "node --flag -"},
+
+		{"args", "echo \"$@\"\n", []string{"a", "b"}, "sh -s -- a b"},
+		{"args like options", "#!/bin/bash\n", []string{"-x", "--", "-s"}, "/bin/bash -s -- -x -- -s"},
+		{"args to other interpreters", "#!/usr/bin/env python3\n", []string{"-c", "x"}, "python3 - -c x"},
+		{"args with spaces", "", []string{"a b", " c "}, "sh -s -- 'a b' ' c '"},
+		{"args with quotes", "", []string{"it's", `say "hi"`, `'`}, `sh -s -- 'it'\''s' 'say "hi"' ''\'''`},
+		{"args with shell characters", "", []string{"$HOME", "a;b", "*", "`id`"}, "sh -s -- '$HOME' 'a;b' '*' '`id`'"},
+		{"empty arg", "", []string{""}, "sh -s -- ''"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScriptCommand([]byte(tt.script), tt.args...); got != tt.want {
+				t.Errorf("ScriptCommand(%q, %q) = %s, want %s", tt.script, tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// The arguments reach the script unchanged when the command is run by a
+// shell.
+func TestScriptCommandArgs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+
+	script := "#!/bin/sh\nprintf '[%s]' \"$@\"\n"
+
+	args := []string{"a b", "it's", `say "hi"`, "$HOME", "a\nb", "", "-s", "--", "*"}
+
+	cmd := exec.Command("sh", "-c", ScriptCommand([]byte(script), args...))
+
+	cmd.Stdin = strings.NewReader(script)
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[" + strings.Join(args, "][") + "]"
+
+	if string(out) != want {
+		t.Errorf("script args %q, want %q", out, want)
+	}
+}
@@ -0,0 +1,72 @@
+package cloudshell
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSFTP opens an SSH connection to the environment and starts an SFTP
+// session on it. The caller must close both clients.
+func (c *Client) OpenSFTP(ctx context.Context, env Environment) (*ssh.Client, *sftp.Client, error) {
+	connection, err := c.Dial(ctx, env)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := sftp.NewClient(connection)
+
+	if err != nil {
+		connection.Close()
+		return nil, nil, err
+	}
+
+	return connection, client, nil
+}
+
+// Upload copies the local file src to dst on the environment and returns
+// the number of bytes copied.
+func (c *Client) Upload(ctx context.Context, env Environment, src, dst string) (int64, error) {
+	info, err := os.Stat(src)
+
+	if err != nil {
+		return 0, err
+	}
+
+	t, err := c.NewTransfer(ctx, env, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer t.Close()
+
+	r := t.uploadFile(ctx, src, dst, info)
+
+	return r.Size, r.Err
+}
+
+// Download copies the file src on the environment to the local file dst and
+// returns the number of bytes copied.
+func (c *Client) Download(ctx context.Context, env Environment, src, dst string) (int64, error) {
+	t, err := c.NewTransfer(ctx, env, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer t.Close()
+
+	info, err := t.sftp.Stat(src)
+
+	if err != nil {
+		return 0, err
+	}
+
+	r := t.downloadFile(ctx, src, dst, info)
+
+	return r.Size, r.Err
+}
@@ -0,0 +1,132 @@
+package cloudshell
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Terminal type sent to the environment when TERM is not set, for example
+// on Windows.
+const defaultTerm = "xterm-256color"
+
+// Shell runs an interactive login shell on the environment.
+//
+// When stdin is a terminal, a pseudo-terminal of the same size and TERM is
+// requested, the local terminal is switched to raw mode until the shell
+// exits, and changes to the window size are sent to the environment.
+//
+// If the shell exits with a non-zero status the error is an *ssh.ExitError.
+// A shell killed by a signal exits with 128 plus the signal number.
+func (c *Client) Shell(ctx context.Context, env Environment, stdin, stdout, stderr *os.File) error {
+	return c.Run(ctx, env, &Command{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
+		TTY:    term.IsTerminal(int(stdin.Fd())),
+	})
+}
+
+// requestPty requests a pseudo-terminal with the TERM and window size of
+// stdout. If stdin is a terminal it is switched to raw mode. The returned
+// function restores the terminal, and is also passed to
+// Options.OnRawTerminal.
+func (c *Client) requestPty(session *ssh.Session, stdin io.Reader, stdout io.Writer) (func(), error) {
+	restore := func() {}
+
+	width, height := 80, 24
+
+	out, _ := stdout.(*os.File)
+
+	if out != nil && term.IsTerminal(int(out.Fd())) {
+		if w, h, err := term.GetSize(int(out.Fd())); err == nil {
+			width, height = w, h
+		}
+	}
+
+	termType := os.Getenv("TERM")
+
+	if termType == "" {
+		termType = defaultTerm
+	}
+
+	c.logf("Request PTY: %s %dx%d", termType, width, height)
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+
+	err := session.RequestPty(termType, height, width, modes)
+
+	if err != nil {
+		return restore, err
+	}
+
+	in, _ := stdin.(*os.File)
+
+	if in == nil || term.IsTerminal(int(in.Fd())) == false {
+		return restore, nil
+	}
+
+	state, err := term.MakeRaw(int(in.Fd()))
+
+	if err != nil {
+		return restore, err
+	}
+
+	restoreConsole := func() {}
+
+	if out != nil {
+		restoreConsole = enableVirtualTerminal(out)
+	}
+
+	stop := func() {}
+
+	if out != nil && term.IsTerminal(int(out.Fd())) {
+		stop = watchWindowSize(int(out.Fd()), func(width, height int) {
+			c.logf("Window size: %dx%d", width, height)
+
+			session.WindowChange(height, width)
+		})
+	}
+
+	var once sync.Once
+
+	restore = func() {
+		once.Do(func() {
+			stop()
+			restoreConsole()
+			term.Restore(int(in.Fd()), state)
+		})
+	}
+
+	if c.opts.OnRawTerminal != nil {
+		c.opts.OnRawTerminal(restore)
+	}
+
+	return restore, nil
+}
+
+// attachStdin copies stdin to the session in a goroutine. Setting
+// session.Stdin instead would make Wait block until stdin is closed, which
+// is after the remote command has exited for an interactive terminal.
+func attachStdin(session *ssh.Session, stdin io.Reader) error {
+	pipe, err := session.StdinPipe()
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		io.Copy(pipe, stdin)
+		pipe.Close()
+	}()
+
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package cloudshell
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// watchWindowSize calls f with the new size of the terminal fd on SIGWINCH
+// until the returned function is called.
+func watchWindowSize(fd int, f func(width, height int)) func() {
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+
+	signal.Notify(c, syscall.SIGWINCH)
+
+	go func() {
+		for {
+			select {
+			case <-c:
+				if width, height, err := term.GetSize(fd); err == nil {
+					f(width, height)
+				}
+
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
+
+// enableVirtualTerminal is only needed on Windows.
+func enableVirtualTerminal(f *os.File) func() {
+	return func() {}
+}
@@ -0,0 +1,66 @@
+//go:build windows
+// +build windows
+
+package cloudshell
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/term"
+)
+
+// Windows has no SIGWINCH, the console size is polled instead
+const windowSizePollInterval = 500 * time.Millisecond
+
+// watchWindowSize calls f with the new size of the console fd when it
+// changes until the returned function is called.
+func watchWindowSize(fd int, f func(width, height int)) func() {
+	done := make(chan struct{})
+
+	go func() {
+		width, height, _ := term.GetSize(fd)
+
+		ticker := time.NewTicker(windowSizePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w, h, err := term.GetSize(fd)
+
+				if err == nil && (w != width || h != height) {
+					width, height = w, h
+					f(width, height)
+				}
+
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// enableVirtualTerminal makes the console interpret the ANSI escape
+// sequences sent by the remote terminal. It returns a function that
+// restores the console mode.
+func enableVirtualTerminal(f *os.File) func() {
+	handle := windows.Handle(f.Fd())
+
+	var mode uint32
+
+	if windows.GetConsoleMode(handle, &mode) != nil {
+		return func() {}
+	}
+
+	windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+
+	return func() {
+		windows.SetConsoleMode(handle, mode)
+	}
+}
@@ -0,0 +1,118 @@
+package cloudshell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrKeyNotFound is returned when the SSH private key file does not exist.
+var ErrKeyNotFound = errors.New("cloudshell: SSH private key not found")
+
+// DefaultKeyFile returns the path of the SSH private key that the Cloud SDK
+// creates for Cloud Shell: ~/.ssh/google_compute_engine
+func DefaultKeyFile() (string, error) {
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ssh", "google_compute_engine"), nil
+}
+
+func (c *Client) keyFile() (string, error) {
+	if c.opts.KeyFile != "" {
+		return c.opts.KeyFile, nil
+	}
+
+	return DefaultKeyFile()
+}
+
+func (c *Client) sshConfig(env Environment, auth []ssh.AuthMethod) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            env.SshUsername,
+		Auth:            auth,
+		HostKeyCallback: c.hostKeyCallback(env),
+	}
+}
+
+// Address returns the host:port of the environment's SSH server.
+func (env Environment) Address() string {
+	return net.JoinHostPort(env.SshHost, fmt.Sprint(env.SshPort))
+}
+
+// Dial opens an SSH connection to a running environment.
+func (c *Client) Dial(ctx context.Context, env Environment) (*ssh.Client, error) {
+	auth, done, err := c.authMethods()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The agent is only needed during the handshake
+	defer done()
+
+	return c.dial(ctx, env, auth)
+}
+
+// VerifyKey checks that the environment accepts an SSH login with signer.
+func (c *Client) VerifyKey(ctx context.Context, env Environment, signer ssh.Signer) error {
+	connection, err := c.dial(ctx, env, []ssh.AuthMethod{ssh.PublicKeys(signer)})
+
+	if err != nil {
+		return err
+	}
+
+	return connection.Close()
+}
+
+func (c *Client) dial(ctx context.Context, env Environment, auth []ssh.AuthMethod) (*ssh.Client, error) {
+	sshConn, chans, reqs, err := c.handshake(ctx, env, c.sshConfig(env, auth))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+func (c *Client) handshake(ctx context.Context, env Environment, config *ssh.ClientConfig) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	if env.SshHost == "" {
+		return nil, nil, nil, errors.New("cloudshell: environment has no SSH host, state: " + env.State)
+	}
+
+	host := env.Address()
+
+	c.logf("Dial: %s", host)
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", host)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Bound the SSH handshake by the context deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return sshConn, chans, reqs, nil
+}
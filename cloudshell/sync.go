@@ -0,0 +1,646 @@
+package cloudshell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//******************************************************************************************
+// Sync
+//
+// A sync makes a destination directory the same as a source directory by
+// copying only the files that are new or changed. A file is changed if its
+// size or modification time (in seconds) is different, or, with
+// SyncOptions.Checksum, its SHA-256 hash. The modification time of each
+// copied file is set to that of the source so that the next sync can skip
+// it.
+//******************************************************************************************
+
+// Operations of a SyncChange
+const (
+	SyncMkdir  = "mkdir"
+	SyncCopy   = "copy"
+	SyncDelete = "delete"
+)
+
+// SyncOptions configures a sync. Recursive and Resume of TransferOptions
+// do not apply.
+type SyncOptions struct {
+	// Delete files and directories of the destination that are not in the
+	// source. Excluded files are not deleted.
+	Delete bool
+
+	// Report the changes without making them.
+	DryRun bool
+
+	// Compare files with the same size by their SHA-256 hash instead of
+	// their modification time.
+	Checksum bool
+
+	// Files to sync. nil syncs all files.
+	Filter *Filter
+
+	// Called with the number of files and bytes to copy, before copying.
+	OnStart func(TransferSummary)
+
+	// Called for each change before it is made, or instead of making it
+	// with DryRun.
+	OnChange func(SyncChange)
+}
+
+// SyncChange is a change to the destination.
+type SyncChange struct {
+	// SyncMkdir, SyncCopy or SyncDelete
+	Op string
+
+	// "/" separated path relative to the root of the sync
+	Path string
+
+	// Why a file is copied: "new", "size", "time" or "checksum"
+	Reason string
+
+	IsDir bool
+	Size  int64
+}
+
+// SyncSummary counts the changes of a sync. The embedded TransferSummary
+// counts the files copied, or with DryRun the files that would be copied.
+type SyncSummary struct {
+	TransferSummary
+
+	Deleted   int
+	Unchanged int
+}
+
+// syncEntry is a file or directory of a sync tree.
+type syncEntry struct {
+	info os.FileInfo
+	dir  bool
+}
+
+// syncSide is the local or remote side of a sync.
+type syncSide struct {
+	remote bool
+	root   string
+}
+
+func (s syncSide) join(rel string) string {
+	if s.remote == true {
+		return path.Join(s.root, rel)
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+// SyncUpload makes the remote directory dst the same as the local directory
+// src.
+func (t *Transfer) SyncUpload(ctx context.Context, src, dst string, opts *SyncOptions) (SyncSummary, error) {
+	return t.sync(ctx, syncSide{false, src}, syncSide{true, dst}, opts)
+}
+
+// SyncDownload makes the local directory dst the same as the remote
+// directory src.
+func (t *Transfer) SyncDownload(ctx context.Context, src, dst string, opts *SyncOptions) (SyncSummary, error) {
+	return t.sync(ctx, syncSide{true, src}, syncSide{false, dst}, opts)
+}
+
+func (t *Transfer) sync(ctx context.Context, src, dst syncSide, opts *SyncOptions) (SyncSummary, error) {
+	var summary SyncSummary
+
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+
+	info, err := t.stat(src, "")
+
+	if err != nil {
+		return summary, err
+	}
+
+	if info.IsDir() == false {
+		return summary, fmt.Errorf("cloudshell: not a directory: %s", src.root)
+	}
+
+	//************************************************************
+	// Scan both sides. The ignore files are read from the source
+	// and also protect excluded files of the destination.
+	//************************************************************
+
+	filter := opts.Filter
+
+	srcTree := t.scan(ctx, &summary.TransferSummary, src, filter, true)
+
+	var dstTree map[string]syncEntry
+
+	if info, err := t.stat(dst, ""); err == nil {
+		if info.IsDir() == false {
+			return summary, fmt.Errorf("cloudshell: not a directory: %s", dst.root)
+		}
+
+		dstTree = t.scan(ctx, &summary.TransferSummary, dst, filter, false)
+	} else if errors.Is(err, fs.ErrNotExist) {
+		if opts.DryRun == false {
+			err = t.mkdir(dst, "")
+
+			if err != nil {
+				return summary, err
+			}
+		}
+	} else {
+		return summary, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return summary, err
+	}
+
+	//************************************************************
+	// Compare
+	//************************************************************
+
+	var mkdirs, copies, conflicts []SyncChange
+
+	var sameSize []string
+
+	for _, rel := range sortedKeys(srcTree) {
+		s := srcTree[rel]
+		d, exists := dstTree[rel]
+
+		if exists == true && s.dir != d.dir {
+			conflicts = append(conflicts, SyncChange{Path: rel, IsDir: s.dir})
+			continue
+		}
+
+		if s.dir == true {
+			if exists == false {
+				mkdirs = append(mkdirs, SyncChange{Op: SyncMkdir, Path: rel, IsDir: true})
+			}
+
+			continue
+		}
+
+		change := SyncChange{Op: SyncCopy, Path: rel, Size: s.info.Size()}
+
+		switch {
+		case exists == false:
+			change.Reason = "new"
+
+		case s.info.Size() != d.info.Size():
+			change.Reason = "size"
+
+		case opts.Checksum == true:
+			sameSize = append(sameSize, rel)
+			continue
+
+		case s.info.ModTime().Unix() != d.info.ModTime().Unix():
+			change.Reason = "time"
+
+		default:
+			summary.Unchanged++
+			continue
+		}
+
+		copies = append(copies, change)
+	}
+
+	if len(sameSize) != 0 {
+		changed, err := t.changedByChecksum(ctx, src, dst, sameSize)
+
+		if err != nil {
+			return summary, err
+		}
+
+		for _, rel := range sameSize {
+			if changed[rel] == false {
+				summary.Unchanged++
+				continue
+			}
+
+			copies = append(copies, SyncChange{Op: SyncCopy, Path: rel, Reason: "checksum", Size: srcTree[rel].info.Size()})
+		}
+
+		sort.Slice(copies, func(i, j int) bool {
+			return copies[i].Path < copies[j].Path
+		})
+	}
+
+	for _, c := range conflicts {
+		t.report(&summary.TransferSummary, FileResult{Src: src.join(c.Path), Dst: dst.join(c.Path), Err: errors.New("a file and a directory have the same name")})
+	}
+
+	//************************************************************
+	// Make the changes
+	//************************************************************
+
+	var total TransferSummary
+
+	for _, c := range copies {
+		total.Files++
+		total.Bytes += c.Size
+	}
+
+	if opts.OnStart != nil {
+		opts.OnStart(total)
+	}
+
+	for _, c := range mkdirs {
+		t.change(opts, c)
+
+		if opts.DryRun == true {
+			continue
+		}
+
+		err := t.mkdir(dst, c.Path)
+
+		if err != nil {
+			t.report(&summary.TransferSummary, FileResult{Src: src.join(c.Path), Dst: dst.join(c.Path), Err: err})
+		}
+	}
+
+	for _, c := range copies {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		t.change(opts, c)
+
+		if opts.DryRun == true {
+			summary.Files++
+			summary.Bytes += c.Size
+			continue
+		}
+
+		t.report(&summary.TransferSummary, t.syncFile(ctx, src, dst, c.Path, srcTree[c.Path].info))
+	}
+
+	if opts.Delete == false {
+		return summary, nil
+	}
+
+	// Reverse order deletes the files of a directory before it
+	deletes := sortedKeys(dstTree)
+
+	for x := len(deletes) - 1; x >= 0; x-- {
+		rel := deletes[x]
+
+		if _, exists := srcTree[rel]; exists == true {
+			continue
+		}
+
+		d := dstTree[rel]
+
+		t.change(opts, SyncChange{Op: SyncDelete, Path: rel, IsDir: d.dir})
+
+		if opts.DryRun == false {
+			err := t.remove(dst, rel, d.dir)
+
+			if err != nil {
+				t.report(&summary.TransferSummary, FileResult{Dst: dst.join(rel), Err: err})
+				continue
+			}
+		}
+
+		summary.Deleted++
+	}
+
+	return summary, nil
+}
+
+func (t *Transfer) change(opts *SyncOptions, c SyncChange) {
+	if opts.OnChange != nil {
+		opts.OnChange(c)
+	}
+}
+
+// syncFile copies one file and sets its modification time.
+func (t *Transfer) syncFile(ctx context.Context, src, dst syncSide, rel string, info os.FileInfo) FileResult {
+	var r FileResult
+
+	mtime := info.ModTime()
+
+	if src.remote == false {
+		r = t.uploadFile(ctx, src.join(rel), dst.join(rel), info)
+
+		if r.Err == nil {
+			r.Err = t.sftp.Chtimes(r.Dst, mtime, mtime)
+		}
+	} else {
+		r = t.downloadFile(ctx, src.join(rel), dst.join(rel), info)
+
+		if r.Err == nil {
+			r.Err = os.Chtimes(r.Dst, mtime, mtime)
+		}
+	}
+
+	return r
+}
+
+func (t *Transfer) stat(side syncSide, rel string) (os.FileInfo, error) {
+	if side.remote == true {
+		return t.sftp.Stat(side.join(rel))
+	}
+
+	return os.Stat(side.join(rel))
+}
+
+func (t *Transfer) mkdir(side syncSide, rel string) error {
+	if side.remote == true {
+		return t.sftp.MkdirAll(side.join(rel))
+	}
+
+	return os.MkdirAll(side.join(rel), 0755)
+}
+
+func (t *Transfer) remove(side syncSide, rel string, dir bool) error {
+	if side.remote == true {
+		if dir == true {
+			return t.sftp.RemoveDirectory(side.join(rel))
+		}
+
+		return t.sftp.Remove(side.join(rel))
+	}
+
+	return os.Remove(side.join(rel))
+}
+
+func sortedKeys(tree map[string]syncEntry) []string {
+	keys := make([]string, 0, len(tree))
+
+	for key := range tree {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+//******************************************************************************************
+// Scanning
+//******************************************************************************************
+
+// scan returns the files and directories under the root of a side, by "/"
+// separated relative path. Entries that cannot be read are reported as
+// failed. With load, the ignore files of each directory are added to the
+// filter before its entries are checked.
+func (t *Transfer) scan(ctx context.Context, summary *TransferSummary, side syncSide, filter *Filter, load bool) map[string]syncEntry {
+	tree := make(map[string]syncEntry)
+
+	if load == true && filter != nil {
+		t.loadIgnoreFiles(side, "", filter)
+	}
+
+	visit := func(rel string, info os.FileInfo, err error) bool {
+		if err != nil {
+			t.report(summary, FileResult{Src: side.join(rel), Err: err})
+			return false
+		}
+
+		if filter.Excluded(rel, info.IsDir()) {
+			return false
+		}
+
+		// A partial file of the destination is continued when its file
+		// is copied, and must not be deleted
+		if load == false && strings.HasSuffix(rel, PartialSuffix) {
+			return false
+		}
+
+		// Follow symbolic links to files
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := t.stat(side, rel)
+
+			if err != nil || target.Mode().IsRegular() == false {
+				return false
+			}
+
+			info = target
+		}
+
+		if info.IsDir() == false && info.Mode().IsRegular() == false {
+			return false
+		}
+
+		tree[rel] = syncEntry{info: info, dir: info.IsDir()}
+
+		if info.IsDir() && load == true && filter != nil {
+			t.loadIgnoreFiles(side, rel, filter)
+		}
+
+		return true
+	}
+
+	if side.remote == true {
+		walker := t.sftp.Walk(path.Clean(side.root))
+
+		for walker.Step() {
+			if ctx.Err() != nil {
+				break
+			}
+
+			rel := remoteRel(path.Clean(side.root), walker.Path())
+
+			if rel == "" {
+				continue
+			}
+
+			if visit(rel, walker.Stat(), walker.Err()) == false && walker.Stat() != nil && walker.Stat().IsDir() {
+				walker.SkipDir()
+			}
+		}
+
+		return tree
+	}
+
+	filepath.WalkDir(side.root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		rel, relErr := filepath.Rel(side.root, p)
+
+		if relErr != nil || rel == "." {
+			return relErr
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		var info os.FileInfo
+
+		if err == nil {
+			info, err = d.Info()
+		}
+
+		if visit(rel, info, err) == false && d != nil && d.IsDir() {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+
+	return tree
+}
+
+// loadIgnoreFiles adds the ignore files of the directory rel to the filter.
+func (t *Transfer) loadIgnoreFiles(side syncSide, rel string, filter *Filter) {
+	for _, name := range filter.IgnoreFiles() {
+		var data []byte
+		var err error
+
+		if side.remote == true {
+			data, err = t.readRemoteFile(side.join(path.Join(rel, name)))
+		} else {
+			data, err = os.ReadFile(side.join(path.Join(rel, name)))
+		}
+
+		if err == nil {
+			filter.addIgnoreFile(rel, data)
+		}
+	}
+}
+
+func (t *Transfer) readRemoteFile(name string) ([]byte, error) {
+	f, err := t.sftp.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+//******************************************************************************************
+// Checksums
+//******************************************************************************************
+
+// changedByChecksum compares the SHA-256 hashes of files on both sides and
+// returns the files that are different.
+func (t *Transfer) changedByChecksum(ctx context.Context, src, dst syncSide, files []string) (map[string]bool, error) {
+	local, remote := src, dst
+
+	if src.remote == true {
+		local, remote = dst, src
+	}
+
+	remoteSums := t.remoteSHA256Batch(ctx, remote, files)
+
+	changed := make(map[string]bool)
+
+	for _, rel := range files {
+		localSum, err := localSHA256(ctx, local.join(rel))
+
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+
+			changed[rel] = true
+			continue
+		}
+
+		remoteSum, ok := remoteSums[rel]
+
+		if ok == false {
+			remoteSum, err = t.remoteSHA256(ctx, remote.join(rel))
+
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return nil, ctxErr
+				}
+
+				changed[rel] = true
+				continue
+			}
+		}
+
+		changed[rel] = localSum != remoteSum
+	}
+
+	return changed, nil
+}
+
+// Number of files hashed by one sha256sum command
+const sha256sumBatch = 200
+
+// remoteSHA256Batch hashes files with one sha256sum command per batch. Files
+// missing from the result are hashed one by one by the caller.
+func (t *Transfer) remoteSHA256Batch(ctx context.Context, side syncSide, files []string) map[string]string {
+	sums := make(map[string]string)
+
+	if t.noSha256sum == true {
+		return sums
+	}
+
+	for start := 0; start < len(files); start += sha256sumBatch {
+		end := start + sha256sumBatch
+
+		if end > len(files) {
+			end = len(files)
+		}
+
+		// NUL terminated output needs no escaping of names
+		cmd := "cd " + shellQuote(side.root) + " && sha256sum -z -- " + ShellQuote(files[start:end]...)
+
+		out, err := t.output(ctx, cmd)
+
+		// sha256sum exits with 1 if some files cannot be read
+		if len(out) == 0 && err != nil {
+			t.c.logf("Transfer: sha256sum: %v", err)
+			return sums
+		}
+
+		for _, line := range strings.Split(string(out), "\x00") {
+			// "<hash>  <name>"
+			if len(line) > 66 && line[64:66] == "  " {
+				sums[strings.TrimPrefix(line[66:], "./")] = strings.ToLower(line[:64])
+			}
+		}
+	}
+
+	return sums
+}
+
+// output runs a command in the environment over the SSH connection of the
+// Transfer and returns its standard output.
+func (t *Transfer) output(ctx context.Context, cmd string) ([]byte, error) {
+	session, err := t.conn.NewSession()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer session.Close()
+
+	type result struct {
+		out []byte
+		err error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		out, err := session.Output(cmd)
+
+		done <- result{out, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.out, r.err
+
+	case <-ctx.Done():
+		session.Close()
+
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,224 @@
+package cloudshell
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A source and destination with every kind of change. The destination has
+// excluded files and a partial file that must survive --delete.
+var (
+	testSyncSrc = map[string]string{
+		".gitignore":  "*.log\nbuild/\n",
+		"a.txt":       "new",
+		"changed.txt": "new contents",
+		"same.txt":    "same",
+		"dir/b.txt":   "b",
+		"app.log":     "excluded",
+	}
+
+	testSyncDst = map[string]string{
+		"changed.txt":      "old",
+		"same.txt":         "same",
+		"old.txt":          "delete",
+		"olddir/x.txt":     "delete",
+		"olddir/sub/y.txt": "delete",
+		"keep.log":         "excluded",
+		"build/out.bin":    "excluded",
+		"z.bin.partial":    "partial",
+	}
+
+	testSyncChanges = []string{
+		"mkdir dir/",
+		"copy .gitignore new",
+		"copy a.txt new",
+		"copy changed.txt size",
+		"copy dir/b.txt new",
+		"delete olddir/x.txt",
+		"delete olddir/sub/y.txt",
+		"delete olddir/sub/",
+		"delete olddir/",
+		"delete old.txt",
+	}
+)
+
+func setupSync(t *testing.T) (src, dst string) {
+	src = t.TempDir()
+	dst = t.TempDir()
+
+	writeTestFiles(t, src, testSyncSrc)
+	writeTestFiles(t, dst, testSyncDst)
+
+	// Unchanged: same size and time
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	for _, dir := range []string{src, dst} {
+		if err := os.Chtimes(filepath.Join(dir, "same.txt"), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return src, dst
+}
+
+func syncTestOptions(changes *[]string) *SyncOptions {
+	return &SyncOptions{
+		Delete: true,
+		Filter: NewFilter(".gitignore"),
+		OnChange: func(c SyncChange) {
+			s := c.Op + " " + c.Path
+
+			if c.IsDir {
+				s += "/"
+			}
+
+			if c.Reason != "" {
+				s += " " + c.Reason
+			}
+
+			*changes = append(*changes, s)
+		},
+	}
+}
+
+func TestSyncDelete(t *testing.T) {
+	for _, upload := range []bool{true, false} {
+		t.Run(fmt.Sprintf("upload=%v", upload), func(t *testing.T) {
+			src, dst := setupSync(t)
+
+			tr := newTestTransfer(t, nil)
+
+			var changes []string
+
+			opts := syncTestOptions(&changes)
+
+			var summary SyncSummary
+			var err error
+
+			if upload {
+				summary, err = tr.SyncUpload(context.Background(), src, dst, opts)
+			} else {
+				summary, err = tr.SyncDownload(context.Background(), src, dst, opts)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Copies are made before deletes, and deletes remove the
+			// files of a directory before the directory
+			if !reflect.DeepEqual(changes, testSyncChanges) {
+				t.Errorf("changes:\n%q\nwant:\n%q", changes, testSyncChanges)
+			}
+
+			want := SyncSummary{TransferSummary: TransferSummary{Files: 4, Bytes: 29}, Deleted: 5, Unchanged: 1}
+
+			if summary != want {
+				t.Errorf("summary %+v, want %+v", summary, want)
+			}
+
+			// Excluded files are neither copied nor deleted
+			wantFiles := map[string]string{}
+
+			for name, data := range testSyncSrc {
+				if name != "app.log" {
+					wantFiles[name] = data
+				}
+			}
+
+			for _, name := range []string{"keep.log", "build/out.bin", "z.bin.partial"} {
+				wantFiles[name] = testSyncDst[name]
+			}
+
+			if got := readTestFiles(t, dst); !reflect.DeepEqual(got, wantFiles) {
+				t.Errorf("destination:\n%v\nwant:\n%v", got, wantFiles)
+			}
+
+			if _, err := os.Stat(filepath.Join(dst, "olddir")); os.IsNotExist(err) == false {
+				t.Errorf("olddir was not deleted: %v", err)
+			}
+
+			// The copies have the time of the source, so a second sync
+			// changes nothing
+			changes = nil
+
+			if upload {
+				summary, err = tr.SyncUpload(context.Background(), src, dst, opts)
+			} else {
+				summary, err = tr.SyncDownload(context.Background(), src, dst, opts)
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(changes) != 0 || summary.Unchanged != 5 {
+				t.Errorf("second sync: %q, %+v", changes, summary)
+			}
+		})
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	src, dst := setupSync(t)
+
+	tr := newTestTransfer(t, nil)
+
+	var changes []string
+
+	opts := syncTestOptions(&changes)
+	opts.DryRun = true
+
+	summary, err := tr.SyncUpload(context.Background(), src, dst, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(changes, testSyncChanges) {
+		t.Errorf("changes:\n%q\nwant:\n%q", changes, testSyncChanges)
+	}
+
+	if summary.Files != 4 || summary.Deleted != 5 {
+		t.Errorf("summary %+v", summary)
+	}
+
+	if got := readTestFiles(t, dst); !reflect.DeepEqual(got, testSyncDst) {
+		t.Errorf("dry run changed the destination:\n%v", got)
+	}
+}
+
+// Without Delete, files that are not in the source are kept.
+func TestSyncNoDelete(t *testing.T) {
+	src, dst := setupSync(t)
+
+	tr := newTestTransfer(t, nil)
+
+	var changes []string
+
+	opts := syncTestOptions(&changes)
+	opts.Delete = false
+
+	summary, err := tr.SyncUpload(context.Background(), src, dst, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Deleted != 0 {
+		t.Errorf("deleted %d files", summary.Deleted)
+	}
+
+	got := readTestFiles(t, dst)
+
+	for name := range testSyncDst {
+		if _, ok := got[name]; ok == false {
+			t.Errorf("%s was deleted", name)
+		}
+	}
+}
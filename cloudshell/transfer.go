@@ -0,0 +1,1323 @@
+package cloudshell
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+//******************************************************************************************
+// File transfers
+//
+// A Transfer copies files and directories between this computer and the
+// environment over one SSH connection. Local paths use the path separator
+// of this computer, remote paths use "/" and are relative to the home
+// directory unless they are absolute.
+//******************************************************************************************
+
+// PartialSuffix is appended to the name of a file while it is copied. The
+// file is renamed when the copy is complete.
+const PartialSuffix = ".partial"
+
+// DefaultChunkSize is the chunk size of parallel copies.
+const DefaultChunkSize = 8 << 20
+
+// Number of bytes at the end of a partial file that are compared with the
+// source before the copy is continued
+const resumeCheckSize = 1 << 20
+
+// ErrNoMatch is reported for a glob pattern that matches no files.
+var ErrNoMatch = errors.New("cloudshell: no files match")
+
+// ErrChecksumMismatch is reported when TransferOptions.Verify finds that a
+// copied file is different from the source.
+var ErrChecksumMismatch = errors.New("cloudshell: SHA-256 checksum mismatch")
+
+// ErrIsDirectory is returned when a directory is copied without
+// TransferOptions.Recursive.
+var ErrIsDirectory = errors.New("cloudshell: is a directory (use recursive)")
+
+// TransferOptions configures a Transfer. The zero value copies single files.
+type TransferOptions struct {
+	// Copy directories and their contents.
+	Recursive bool
+
+	// Number of SSH connections used to copy large files in chunks. 0 or 1
+	// copies each file over one SFTP stream.
+	Parallel int
+
+	// Size of the chunks of a parallel copy. The default is
+	// DefaultChunkSize.
+	ChunkSize int64
+
+	// Continue copying into an existing destination file that is the
+	// start of the source. A PartialSuffix file left by an interrupted copy
+	// is always continued.
+	Resume bool
+
+	// Compare the SHA-256 hash of each file on both sides after it is
+	// copied.
+	Verify bool
+
+	// Called after each file is copied, or fails to be copied.
+	OnFile func(FileResult)
+
+	// Called as the bytes of a file are copied. Calls are not concurrent,
+	// even for parallel copies.
+	OnProgress func(Progress)
+}
+
+// FileResult is the result of copying one file.
+type FileResult struct {
+	Src string
+	Dst string
+
+	// Bytes copied
+	Size int64
+
+	// Offset the copy continued from, or 0
+	Resumed int64
+
+	// Hex SHA-256 hash of the file, if it was verified
+	SHA256 string
+
+	Err error
+}
+
+// Progress is the progress of the file that is being copied.
+type Progress struct {
+	Src string
+	Dst string
+
+	// Size of the file
+	Size int64
+
+	// Bytes of the file that are copied, including a resumed part
+	Done int64
+}
+
+// TransferSummary counts the files and bytes copied by a Transfer.
+type TransferSummary struct {
+	Files  int
+	Failed int
+	Bytes  int64
+}
+
+// Transfer copies files between this computer and the environment.
+type Transfer struct {
+	c    *Client
+	env  Environment
+	conn *ssh.Client
+	sftp *sftp.Client
+	opts TransferOptions
+
+	// Extra connections for parallel copies, opened on first use
+	conns []*ssh.Client
+	pool  []*sftp.Client
+
+	// Progress of the file that is being copied, or nil
+	progress *progress
+
+	// sha256sum cannot be run in the environment
+	noSha256sum bool
+}
+
+// NewTransfer opens an SSH connection and SFTP session to the environment.
+// opts may be nil. The caller must call Close.
+func (c *Client) NewTransfer(ctx context.Context, env Environment, opts *TransferOptions) (*Transfer, error) {
+	conn, client, err := c.OpenSFTP(ctx, env)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transfer{c: c, env: env, conn: conn, sftp: client}
+
+	if opts != nil {
+		t.opts = *opts
+	}
+
+	return t, nil
+}
+
+// SFTP returns the SFTP client of the Transfer.
+func (t *Transfer) SFTP() *sftp.Client {
+	return t.sftp
+}
+
+// Close closes the SFTP sessions and SSH connections.
+func (t *Transfer) Close() error {
+	for x, conn := range t.conns {
+		t.pool[x+1].Close()
+		conn.Close()
+	}
+
+	t.sftp.Close()
+
+	return t.conn.Close()
+}
+
+func (t *Transfer) report(summary *TransferSummary, r FileResult) {
+	if r.Err != nil {
+		summary.Failed++
+	} else {
+		summary.Files++
+		summary.Bytes += r.Size
+	}
+
+	if t.opts.OnFile != nil {
+		t.opts.OnFile(r)
+	}
+}
+
+// remoteIsDir reports whether a remote path is an existing directory.
+func (t *Transfer) remoteIsDir(p string) bool {
+	info, err := t.sftp.Stat(p)
+
+	return err == nil && info.IsDir()
+}
+
+// localIsDir reports whether a local path is an existing directory.
+func localIsDir(p string) bool {
+	info, err := os.Stat(p)
+
+	return err == nil && info.IsDir()
+}
+
+//******************************************************************************************
+// Upload
+//******************************************************************************************
+
+// Upload copies the local file or directory src to dst on the environment.
+// If dst is an existing directory, src is copied into it.
+//
+// Errors copying individual files of a directory are reported to OnFile and
+// counted in the summary, and the copy continues. The returned error is for
+// errors that stop the whole copy.
+func (t *Transfer) Upload(ctx context.Context, src, dst string) (TransferSummary, error) {
+	var summary TransferSummary
+
+	info, err := os.Stat(src)
+
+	if err != nil {
+		return summary, err
+	}
+
+	if t.remoteIsDir(dst) {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+
+	if info.IsDir() == false {
+		t.report(&summary, t.uploadFile(ctx, src, dst, info))
+
+		return summary, nil
+	}
+
+	if t.opts.Recursive == false {
+		return summary, fmt.Errorf("%w: %s", ErrIsDirectory, src)
+	}
+
+	err = filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		rel, relErr := filepath.Rel(src, p)
+
+		if relErr != nil {
+			return relErr
+		}
+
+		target := path.Join(dst, filepath.ToSlash(rel))
+
+		if err != nil {
+			// Unreadable directory or file: report it and go on
+			t.report(&summary, FileResult{Src: p, Dst: target, Err: err})
+
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			err = t.sftp.MkdirAll(target)
+
+			if err != nil {
+				t.report(&summary, FileResult{Src: p, Dst: target, Err: err})
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		// Follow symbolic links to files
+		info, err := os.Stat(p)
+
+		if err == nil && info.Mode().IsRegular() == false {
+			err = fmt.Errorf("not a regular file: %s", info.Mode().Type())
+		}
+
+		if err != nil {
+			t.report(&summary, FileResult{Src: p, Dst: target, Err: err})
+			return nil
+		}
+
+		t.report(&summary, t.uploadFile(ctx, p, target, info))
+
+		return nil
+	})
+
+	return summary, err
+}
+
+// uploadFile copies one local file to the environment. The file is written
+// to dst with PartialSuffix and renamed when it is complete.
+func (t *Transfer) uploadFile(ctx context.Context, src, dst string, info os.FileInfo) FileResult {
+	r := FileResult{Src: src, Dst: dst}
+
+	srcFile, err := os.Open(src)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	defer srcFile.Close()
+
+	target, offset := t.remoteResumeTarget(srcFile, info.Size(), dst)
+
+	flags := os.O_WRONLY | os.O_CREATE
+
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	dstFile, err := t.sftp.OpenFile(target, flags)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	r.Resumed = offset
+
+	t.startProgress(src, dst, info.Size(), offset)
+	defer t.endProgress()
+
+	_, err = srcFile.Seek(offset, io.SeekStart)
+
+	if err == nil {
+		_, err = dstFile.Seek(offset, io.SeekStart)
+	}
+
+	if err == nil {
+		if t.parallel(info.Size() - offset) {
+			r.Size, err = t.WriteRemote(ctx, target, srcFile, offset, info.Size())
+		} else {
+			r.Size, err = io.Copy(dstFile, contextReader{ctx, srcFile, t.progress})
+		}
+	}
+
+	if err != nil {
+		dstFile.Close()
+		r.Err = err
+		return r
+	}
+
+	dstFile.Chmod(info.Mode().Perm())
+
+	err = dstFile.Close()
+
+	if err == nil && target != dst {
+		err = t.remoteRename(target, dst)
+	}
+
+	if err == nil && t.opts.Verify == true {
+		r.SHA256, err = t.verify(ctx, src, dst)
+	}
+
+	r.Err = err
+
+	return r
+}
+
+// remoteResumeTarget returns the remote file to write and the offset to
+// continue from.
+func (t *Transfer) remoteResumeTarget(src io.ReaderAt, size int64, dst string) (string, int64) {
+	partial := dst + PartialSuffix
+
+	if offset, ok := t.remoteResumeOffset(src, size, partial); ok {
+		return partial, offset
+	}
+
+	if t.opts.Resume == true {
+		if offset, ok := t.remoteResumeOffset(src, size, dst); ok && offset != 0 {
+			return dst, offset
+		}
+	}
+
+	return partial, 0
+}
+
+// remoteResumeOffset checks a remote file against src. ok is false if the
+// file does not exist.
+func (t *Transfer) remoteResumeOffset(src io.ReaderAt, size int64, name string) (offset int64, ok bool) {
+	f, err := t.sftp.Open(name)
+
+	if err != nil {
+		return 0, false
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil || info.Mode().IsRegular() == false {
+		return 0, false
+	}
+
+	return resumeOffset(src, f, size, info.Size()), true
+}
+
+// remoteRename replaces newname with oldname. Renaming over an existing file
+// needs the posix-rename extension, which OpenSSH supports.
+func (t *Transfer) remoteRename(oldname, newname string) error {
+	err := t.sftp.PosixRename(oldname, newname)
+
+	if err == nil {
+		return nil
+	}
+
+	t.sftp.Remove(newname)
+
+	return t.sftp.Rename(oldname, newname)
+}
+
+//******************************************************************************************
+// Download
+//******************************************************************************************
+
+// Download copies the file or directory src on the environment to the local
+// path dst. If dst is an existing directory, src is copied into it.
+//
+// Errors are handled as for Upload.
+func (t *Transfer) Download(ctx context.Context, src, dst string) (TransferSummary, error) {
+	var summary TransferSummary
+
+	src = path.Clean(src)
+
+	info, err := t.sftp.Stat(src)
+
+	if err != nil {
+		return summary, err
+	}
+
+	if localIsDir(dst) {
+		dst = filepath.Join(dst, path.Base(src))
+	}
+
+	if info.IsDir() == false {
+		t.report(&summary, t.downloadFile(ctx, src, dst, info))
+
+		return summary, nil
+	}
+
+	if t.opts.Recursive == false {
+		return summary, fmt.Errorf("%w: %s", ErrIsDirectory, src)
+	}
+
+	walker := t.sftp.Walk(src)
+
+	for walker.Step() {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		p := walker.Path()
+
+		target := filepath.Join(dst, filepath.FromSlash(remoteRel(src, p)))
+
+		if err := walker.Err(); err != nil {
+			t.report(&summary, FileResult{Src: p, Dst: target, Err: err})
+			continue
+		}
+
+		info := walker.Stat()
+
+		if info.IsDir() {
+			err := os.MkdirAll(target, 0755)
+
+			if err != nil {
+				t.report(&summary, FileResult{Src: p, Dst: target, Err: err})
+				walker.SkipDir()
+			}
+
+			continue
+		}
+
+		var err error
+
+		// Follow symbolic links to files
+		if info.Mode()&os.ModeSymlink != 0 {
+			info, err = t.sftp.Stat(p)
+		}
+
+		if err == nil && info.Mode().IsRegular() == false {
+			err = fmt.Errorf("not a regular file: %s", info.Mode().Type())
+		}
+
+		if err != nil {
+			t.report(&summary, FileResult{Src: p, Dst: target, Err: err})
+			continue
+		}
+
+		t.report(&summary, t.downloadFile(ctx, p, target, info))
+	}
+
+	return summary, nil
+}
+
+// remoteRel returns the path of p relative to the directory root, which is
+// a clean path.
+func remoteRel(root, p string) string {
+	if p == root {
+		return ""
+	}
+
+	if root == "." {
+		return p
+	}
+
+	return strings.TrimPrefix(p, strings.TrimSuffix(root, "/")+"/")
+}
+
+// downloadFile copies one file from the environment to a local file. The
+// file is written to dst with PartialSuffix and renamed when it is complete.
+func (t *Transfer) downloadFile(ctx context.Context, src, dst string, info os.FileInfo) FileResult {
+	r := FileResult{Src: src, Dst: dst}
+
+	srcFile, err := t.sftp.Open(src)
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	defer srcFile.Close()
+
+	target, offset := t.localResumeTarget(srcFile, info.Size(), dst)
+
+	flags := os.O_WRONLY | os.O_CREATE
+
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	dstFile, err := os.OpenFile(target, flags, info.Mode().Perm())
+
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	r.Resumed = offset
+
+	t.startProgress(src, dst, info.Size(), offset)
+	defer t.endProgress()
+
+	_, err = srcFile.Seek(offset, io.SeekStart)
+
+	if err == nil {
+		_, err = dstFile.Seek(offset, io.SeekStart)
+	}
+
+	if err == nil {
+		if t.parallel(info.Size() - offset) {
+			r.Size, err = t.ReadRemote(ctx, src, dstFile, offset, info.Size())
+		} else {
+			r.Size, err = io.Copy(contextWriter{ctx, dstFile, t.progress}, srcFile)
+		}
+	}
+
+	if err != nil {
+		dstFile.Close()
+		r.Err = err
+		return r
+	}
+
+	err = dstFile.Close()
+
+	if err == nil && target != dst {
+		err = os.Rename(target, dst)
+	}
+
+	if err == nil && t.opts.Verify == true {
+		r.SHA256, err = t.verify(ctx, dst, src)
+	}
+
+	r.Err = err
+
+	return r
+}
+
+// localResumeTarget returns the local file to write and the offset to
+// continue from.
+func (t *Transfer) localResumeTarget(src io.ReaderAt, size int64, dst string) (string, int64) {
+	partial := dst + PartialSuffix
+
+	if offset, ok := localResumeOffset(src, size, partial); ok {
+		return partial, offset
+	}
+
+	if t.opts.Resume == true {
+		if offset, ok := localResumeOffset(src, size, dst); ok && offset != 0 {
+			return dst, offset
+		}
+	}
+
+	return partial, 0
+}
+
+// localResumeOffset checks a local file against src. ok is false if the file
+// does not exist.
+func localResumeOffset(src io.ReaderAt, size int64, name string) (offset int64, ok bool) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return 0, false
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil || info.Mode().IsRegular() == false {
+		return 0, false
+	}
+
+	return resumeOffset(src, f, size, info.Size()), true
+}
+
+//******************************************************************************************
+// Resume
+//******************************************************************************************
+
+// resumeOffset returns the offset to continue copying src to dst from: the
+// size of dst if dst is not larger than src and its last bytes are the same
+// as the bytes of src at that offset, otherwise 0. A read error also gives
+// 0, so that the copy starts over.
+func resumeOffset(src, dst io.ReaderAt, srcSize, dstSize int64) int64 {
+	if dstSize == 0 || dstSize > srcSize {
+		return 0
+	}
+
+	n := int64(resumeCheckSize)
+
+	if n > dstSize {
+		n = dstSize
+	}
+
+	srcHash, err := hashRange(src, dstSize-n, n)
+
+	if err != nil {
+		return 0
+	}
+
+	dstHash, err := hashRange(dst, dstSize-n, n)
+
+	if err != nil || bytes.Equal(srcHash, dstHash) == false {
+		return 0
+	}
+
+	return dstSize
+}
+
+// hashRange returns the SHA-256 hash of n bytes of r at offset off.
+func hashRange(r io.ReaderAt, off, n int64) ([]byte, error) {
+	h := sha256.New()
+
+	_, err := io.Copy(h, io.NewSectionReader(r, off, n))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+//******************************************************************************************
+// Parallel copies
+//
+// A large file is split into chunks that are copied with ReadAt and WriteAt
+// by one goroutine per connection. Each SSH connection has its own flow
+// control window, so several connections get closer to the speed of the
+// link than one.
+//******************************************************************************************
+
+// WriteRemote copies the bytes of r from offset to size to the existing
+// remote file name, in chunks over TransferOptions.Parallel connections.
+// It returns the number of bytes copied. If the copy fails, the file is
+// truncated after the last chunk that was complete, so that it can be
+// resumed.
+func (t *Transfer) WriteRemote(ctx context.Context, name string, r io.ReaderAt, offset, size int64) (int64, error) {
+	n, done, err := t.copyChunks(ctx, name, os.O_WRONLY, offset, size, func(f *sftp.File, buf []byte, off int64) (int, error) {
+		n, err := readChunk(r, buf, off)
+
+		if err != nil {
+			return 0, err
+		}
+
+		return f.WriteAt(buf[:n], off)
+	})
+
+	if err != nil && done < size {
+		t.sftp.Truncate(name, done)
+	}
+
+	return n, err
+}
+
+// ReadRemote copies the bytes of the remote file name from offset to size
+// to w, in chunks over TransferOptions.Parallel connections. It returns the
+// number of bytes copied. If the copy fails and w has a Truncate method, w
+// is truncated after the last chunk that was complete.
+func (t *Transfer) ReadRemote(ctx context.Context, name string, w io.WriterAt, offset, size int64) (int64, error) {
+	n, done, err := t.copyChunks(ctx, name, os.O_RDONLY, offset, size, func(f *sftp.File, buf []byte, off int64) (int, error) {
+		n, err := readChunk(f, buf, off)
+
+		if err != nil {
+			return 0, err
+		}
+
+		return w.WriteAt(buf[:n], off)
+	})
+
+	if truncater, ok := w.(interface{ Truncate(int64) error }); ok && err != nil && done < size {
+		truncater.Truncate(done)
+	}
+
+	return n, err
+}
+
+// readChunk reads len(buf) bytes at off.
+func readChunk(r io.ReaderAt, buf []byte, off int64) (int, error) {
+	n, err := r.ReadAt(buf, off)
+
+	if n == len(buf) {
+		return n, nil
+	}
+
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (t *Transfer) chunkSize() int64 {
+	if t.opts.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+
+	return t.opts.ChunkSize
+}
+
+// parallel reports whether n bytes are copied in chunks.
+func (t *Transfer) parallel(n int64) bool {
+	return t.opts.Parallel > 1 && n > t.chunkSize()
+}
+
+// streams returns the SFTP clients for parallel copies. If a connection
+// cannot be opened, fewer are used.
+func (t *Transfer) streams(ctx context.Context) []*sftp.Client {
+	if t.pool != nil {
+		return t.pool
+	}
+
+	t.pool = []*sftp.Client{t.sftp}
+
+	for len(t.pool) < t.opts.Parallel {
+		conn, client, err := t.c.OpenSFTP(ctx, t.env)
+
+		if err != nil {
+			t.c.logf("Transfer: %v, using %d connections", err, len(t.pool))
+			break
+		}
+
+		t.conns = append(t.conns, conn)
+		t.pool = append(t.pool, client)
+	}
+
+	return t.pool
+}
+
+// copyChunks calls copyChunk for each chunk of the remote file name from offset
+// to size, with one goroutine per stream. It returns the number of bytes
+// copied and the end of the chunks that were complete from offset.
+func (t *Transfer) copyChunks(ctx context.Context, name string, flag int, offset, size int64, copyChunk func(f *sftp.File, buf []byte, off int64) (int, error)) (int64, int64, error) {
+	streams := t.streams(ctx)
+
+	chunk := t.chunkSize()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan int64)
+
+	go func() {
+		defer close(chunks)
+
+		for off := offset; off < size; off += chunk {
+			select {
+			case chunks <- off:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		copied   int64
+		complete = make(map[int64]bool)
+		firstErr error
+	)
+
+	fail := func(err error) {
+		mu.Lock()
+
+		if firstErr == nil {
+			firstErr = err
+		}
+
+		mu.Unlock()
+
+		cancel()
+	}
+
+	for _, client := range streams {
+		wg.Add(1)
+
+		go func(client *sftp.Client) {
+			defer wg.Done()
+
+			f, err := client.OpenFile(name, flag)
+
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			defer f.Close()
+
+			buf := make([]byte, chunk)
+
+			for off := range chunks {
+				if err := ctx.Err(); err != nil {
+					fail(err)
+					return
+				}
+
+				n := chunk
+
+				if size-off < n {
+					n = size - off
+				}
+
+				written, err := copyChunk(f, buf[:n], off)
+
+				t.progress.add(int64(written))
+
+				mu.Lock()
+
+				copied += int64(written)
+
+				if err == nil {
+					complete[off] = true
+				}
+
+				mu.Unlock()
+
+				if err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(client)
+	}
+
+	wg.Wait()
+
+	done := offset
+
+	for done < size && complete[done] {
+		done += chunk
+	}
+
+	if done > size {
+		done = size
+	}
+
+	if firstErr == nil && done < size {
+		firstErr = ctx.Err()
+	}
+
+	return copied, done, firstErr
+}
+
+//******************************************************************************************
+// Verification
+//
+// The remote hash is computed by sha256sum in the environment, so that the
+// file is not read again over the network. If sha256sum cannot be run, the
+// file is read back over SFTP and hashed here.
+//******************************************************************************************
+
+// verify compares the SHA-256 hash of a local and a remote file and returns
+// the hash in hex.
+func (t *Transfer) verify(ctx context.Context, local, remote string) (string, error) {
+	localSum, err := localSHA256(ctx, local)
+
+	if err != nil {
+		return "", fmt.Errorf("verify: %w", err)
+	}
+
+	remoteSum, err := t.remoteSHA256(ctx, remote)
+
+	if err != nil {
+		return "", fmt.Errorf("verify: %w", err)
+	}
+
+	if localSum != remoteSum {
+		return "", fmt.Errorf("%w: local %s, Cloud Shell %s", ErrChecksumMismatch, localSum, remoteSum)
+	}
+
+	return localSum, nil
+}
+
+func localSHA256(ctx context.Context, name string) (string, error) {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, contextReader{ctx, f, nil})
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (t *Transfer) remoteSHA256(ctx context.Context, name string) (string, error) {
+	if t.noSha256sum == false {
+		sum, err := t.execSHA256(ctx, name)
+
+		if err == nil {
+			return sum, nil
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+
+		t.c.logf("Transfer: sha256sum: %v, reading the file back", err)
+
+		t.noSha256sum = true
+	}
+
+	f, err := t.sftp.Open(name)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	// Keep the concurrent sftp.File.WriteTo
+	_, err = io.Copy(contextWriter{ctx, h, nil}, f)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// execSHA256 runs sha256sum in the environment.
+func (t *Transfer) execSHA256(ctx context.Context, name string) (string, error) {
+	out, err := t.output(ctx, "sha256sum -- "+shellQuote(name))
+
+	if err != nil {
+		return "", err
+	}
+
+	// "<hash>  <name>", with a backslash before the hash if the name
+	// has special characters
+	fields := strings.Fields(string(out))
+
+	if len(fields) == 0 {
+		return "", errors.New("no output")
+	}
+
+	sum := strings.TrimPrefix(fields[0], "\\")
+
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("unexpected output %q", out)
+	}
+
+	return strings.ToLower(sum), nil
+}
+
+//******************************************************************************************
+// Multiple files and glob patterns
+//
+// Patterns use the syntax of path.Match. Local patterns are expanded here
+// because the Windows command prompt passes them to programs unexpanded.
+// A path that exists is used as is, even if it contains pattern characters.
+//******************************************************************************************
+
+// UploadFiles copies local files or directories, which may be glob
+// patterns, to dst on the environment. When more than one file is copied or
+// dst ends with "/", dst is a directory and is created if necessary.
+//
+// A pattern or file that cannot be copied is reported to OnFile and the copy
+// goes on with the next one.
+func (t *Transfer) UploadFiles(ctx context.Context, srcs []string, dst string) (TransferSummary, error) {
+	var summary TransferSummary
+
+	var files []string
+
+	for _, src := range srcs {
+		matches, err := localGlob(src)
+
+		if err != nil {
+			t.report(&summary, FileResult{Src: src, Err: err})
+			continue
+		}
+
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return summary, nil
+	}
+
+	if len(files) > 1 || strings.HasSuffix(dst, "/") {
+		err := t.sftp.MkdirAll(dst)
+
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	for _, file := range files {
+		s, err := t.Upload(ctx, file, dst)
+
+		summary.add(s)
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return summary, ctxErr
+		}
+
+		if err != nil {
+			t.report(&summary, FileResult{Src: file, Dst: dst, Err: err})
+		}
+	}
+
+	return summary, nil
+}
+
+// DownloadFiles copies files or directories on the environment, which may
+// be glob patterns, to the local path dst. dst is handled as for
+// UploadFiles.
+func (t *Transfer) DownloadFiles(ctx context.Context, srcs []string, dst string) (TransferSummary, error) {
+	var summary TransferSummary
+
+	var files []string
+
+	for _, src := range srcs {
+		matches, err := t.remoteGlob(src)
+
+		if err != nil {
+			t.report(&summary, FileResult{Src: src, Err: err})
+			continue
+		}
+
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return summary, nil
+	}
+
+	if len(files) > 1 || strings.HasSuffix(dst, "/") || strings.HasSuffix(dst, string(filepath.Separator)) {
+		err := os.MkdirAll(dst, 0755)
+
+		if err != nil {
+			return summary, err
+		}
+	}
+
+	for _, file := range files {
+		s, err := t.Download(ctx, file, dst)
+
+		summary.add(s)
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return summary, ctxErr
+		}
+
+		if err != nil {
+			t.report(&summary, FileResult{Src: file, Dst: dst, Err: err})
+		}
+	}
+
+	return summary, nil
+}
+
+func (s *TransferSummary) add(o TransferSummary) {
+	s.Files += o.Files
+	s.Failed += o.Failed
+	s.Bytes += o.Bytes
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// localGlob expands a local pattern.
+func localGlob(pattern string) ([]string, error) {
+	if _, err := os.Lstat(pattern); err == nil || hasMeta(pattern) == false {
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	return matches, nil
+}
+
+// remoteGlob expands a pattern on the environment.
+func (t *Transfer) remoteGlob(pattern string) ([]string, error) {
+	if _, err := t.sftp.Lstat(pattern); err == nil || hasMeta(pattern) == false {
+		return []string{pattern}, nil
+	}
+
+	matches, err := t.sftp.Glob(pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(matches) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+//******************************************************************************************
+// Cancellation
+//
+// The writer is wrapped rather than the reader on downloads so that io.Copy
+// still uses the concurrent sftp.File.WriteTo.
+//******************************************************************************************
+
+type contextReader struct {
+	ctx      context.Context
+	r        io.Reader
+	progress *progress
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+
+	r.progress.add(int64(n))
+
+	return n, err
+}
+
+type contextWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	progress *progress
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := w.w.Write(p)
+
+	w.progress.add(int64(n))
+
+	return n, err
+}
+
+//******************************************************************************************
+// Progress
+//******************************************************************************************
+
+type progress struct {
+	mu sync.Mutex
+	p  Progress
+	fn func(Progress)
+}
+
+// add counts n bytes copied. It does nothing on a nil progress.
+func (p *progress) add(n int64) {
+	if p == nil || n == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.p.Done += n
+
+	p.fn(p.p)
+}
+
+func (t *Transfer) startProgress(src, dst string, size, offset int64) {
+	if t.opts.OnProgress == nil {
+		return
+	}
+
+	t.progress = &progress{p: Progress{Src: src, Dst: dst, Size: size, Done: offset}, fn: t.opts.OnProgress}
+
+	t.opts.OnProgress(t.progress.p)
+}
+
+func (t *Transfer) endProgress() {
+	t.progress = nil
+}
+
+// UploadTotal returns the number of files and bytes that UploadFiles would
+// copy, for a progress display. Files that cannot be read are not counted.
+func (t *Transfer) UploadTotal(srcs []string) TransferSummary {
+	var total TransferSummary
+
+	for _, src := range srcs {
+		matches, err := localGlob(src)
+
+		if err != nil {
+			continue
+		}
+
+		for _, match := range matches {
+			filepath.WalkDir(match, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return nil
+				}
+
+				if d.IsDir() {
+					if t.opts.Recursive == false {
+						return fs.SkipDir
+					}
+
+					return nil
+				}
+
+				info, err := os.Stat(p)
+
+				if err == nil && info.Mode().IsRegular() {
+					total.Files++
+					total.Bytes += info.Size()
+				}
+
+				return nil
+			})
+		}
+	}
+
+	return total
+}
+
+// DownloadTotal returns the number of files and bytes that DownloadFiles
+// would copy, as for UploadTotal.
+func (t *Transfer) DownloadTotal(srcs []string) TransferSummary {
+	var total TransferSummary
+
+	for _, src := range srcs {
+		matches, err := t.remoteGlob(src)
+
+		if err != nil {
+			continue
+		}
+
+		for _, match := range matches {
+			walker := t.sftp.Walk(match)
+
+			for walker.Step() {
+				if walker.Err() != nil {
+					continue
+				}
+
+				info := walker.Stat()
+
+				if info.IsDir() {
+					if t.opts.Recursive == false {
+						walker.SkipDir()
+					}
+
+					continue
+				}
+
+				if info.Mode()&os.ModeSymlink != 0 {
+					info, err = t.sftp.Stat(walker.Path())
+
+					if err != nil {
+						continue
+					}
+				}
+
+				if info.Mode().IsRegular() {
+					total.Files++
+					total.Bytes += info.Size()
+				}
+			}
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,536 @@
+package cloudshell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestTransfer returns a Transfer whose SFTP session is served in
+// process from the local file system, so remote paths are local paths.
+// There is no SSH connection: commands cannot be run and Close must not be
+// called.
+func newTestTransfer(t *testing.T, opts *TransferOptions) *Transfer {
+	t.Helper()
+
+	tr := &Transfer{c: &Client{}, sftp: newTestSFTP(t)}
+
+	if opts != nil {
+		tr.opts = *opts
+	}
+
+	return tr
+}
+
+// newTestParallelTransfer returns a Transfer like newTestTransfer that
+// copies chunks of chunkSize bytes over the given number of SFTP sessions.
+func newTestParallelTransfer(t *testing.T, streams int, chunkSize int64) *Transfer {
+	t.Helper()
+
+	tr := newTestTransfer(t, &TransferOptions{Parallel: streams, ChunkSize: chunkSize})
+
+	tr.pool = []*sftp.Client{tr.sftp}
+
+	for len(tr.pool) < streams {
+		tr.pool = append(tr.pool, newTestSFTP(t))
+	}
+
+	return tr
+}
+
+// newTestSFTP returns an SFTP client with a server in process.
+func newTestSFTP(t *testing.T) *sftp.Client {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+
+	server, err := sftp.NewServer(serverConn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	return client
+}
+
+// writeTestFiles creates files under dir, by "/" separated relative path.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTestFiles returns the files under dir, by "/" separated relative
+// path.
+func readTestFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(p)
+
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+
+		files[filepath.ToSlash(rel)] = string(data)
+
+		return err
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+// testData returns n bytes that are the same in every run.
+func testData(n int) []byte {
+	b := make([]byte, n)
+
+	rand.New(rand.NewSource(int64(n))).Read(b)
+
+	return b
+}
+
+// errReaderAt fails every read.
+type errReaderAt struct{}
+
+func (errReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errors.New("read error")
+}
+
+func TestResumeOffset(t *testing.T) {
+	src := testData(resumeCheckSize + 1000)
+
+	changed := func(b []byte, x int) []byte {
+		b = append([]byte(nil), b...)
+		b[x] ^= 0xff
+		return b
+	}
+
+	tests := []struct {
+		name string
+		dst  []byte
+		want int64
+	}{
+		{"empty", nil, 0},
+		{"matching tail", src[:500], 500},
+		{"mismatched tail", changed(src[:500], 499), 0},
+		{"mismatched start of a short file", changed(src[:500], 0), 0},
+		{"complete", src, int64(len(src))},
+		{"larger than the source", append(append([]byte(nil), src...), 'x'), 0},
+		{"foreign file of the same size", bytes.Repeat([]byte("x"), len(src)), 0},
+
+		// Only the last resumeCheckSize bytes are compared
+		{"long file, mismatched tail", changed(src[:resumeCheckSize+10], resumeCheckSize+9), 0},
+		{"long file, change before the tail", changed(src[:resumeCheckSize+10], 5), resumeCheckSize + 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resumeOffset(bytes.NewReader(src), bytes.NewReader(tt.dst), int64(len(src)), int64(len(tt.dst)))
+
+			if got != tt.want {
+				t.Errorf("resumeOffset = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// A read error starts over
+	if got := resumeOffset(errReaderAt{}, bytes.NewReader(src[:500]), int64(len(src)), 500); got != 0 {
+		t.Errorf("read error of the source: resumeOffset = %d, want 0", got)
+	}
+
+	if got := resumeOffset(bytes.NewReader(src), errReaderAt{}, int64(len(src)), 500); got != 0 {
+		t.Errorf("read error of the destination: resumeOffset = %d, want 0", got)
+	}
+}
+
+// TestTransferResume copies over existing .partial and destination files in
+// both directions. The result is always a copy of the source without a
+// .partial file; Resumed tells whether the existing bytes were kept.
+func TestTransferResume(t *testing.T) {
+	src := testData(2*resumeCheckSize + 1000)
+	prefix := src[:resumeCheckSize+500]
+
+	mismatched := append([]byte(nil), prefix...)
+	mismatched[len(mismatched)-1] ^= 0xff
+
+	larger := append(append([]byte(nil), src...), "more"...)
+
+	tests := []struct {
+		name        string
+		partial     []byte
+		dst         []byte
+		resume      bool
+		wantResumed int64
+	}{
+		{"no partial", nil, nil, false, 0},
+		{"partial with matching tail", prefix, nil, false, int64(len(prefix))},
+		{"partial with mismatched tail", mismatched, nil, false, 0},
+		{"partial larger than the source", larger, nil, false, 0},
+		{"complete partial", src, nil, false, int64(len(src))},
+		{"partial is preferred to the destination", prefix, src[:100], true, int64(len(prefix))},
+
+		// --resume continues the destination itself
+		{"resume destination prefix", nil, prefix, true, int64(len(prefix))},
+		{"resume foreign destination", nil, testData(1000), true, 0},
+		{"resume destination with mismatched tail", nil, mismatched, true, 0},
+		{"resume destination larger than the source", nil, larger, true, 0},
+		{"destination prefix without resume", nil, prefix, false, 0},
+	}
+
+	for _, upload := range []bool{true, false} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("upload=%v/%s", upload, tt.name), func(t *testing.T) {
+				dir := t.TempDir()
+
+				srcName := filepath.Join(dir, "src")
+				dstName := filepath.Join(dir, "dst")
+
+				writeTestFiles(t, dir, map[string]string{"src": string(src)})
+
+				if tt.partial != nil {
+					writeTestFiles(t, dir, map[string]string{"dst" + PartialSuffix: string(tt.partial)})
+				}
+
+				if tt.dst != nil {
+					writeTestFiles(t, dir, map[string]string{"dst": string(tt.dst)})
+				}
+
+				var results []FileResult
+
+				tr := newTestTransfer(t, &TransferOptions{
+					Resume: tt.resume,
+					OnFile: func(r FileResult) {
+						results = append(results, r)
+					},
+				})
+
+				var summary TransferSummary
+				var err error
+
+				if upload {
+					summary, err = tr.Upload(context.Background(), srcName, dstName)
+				} else {
+					summary, err = tr.Download(context.Background(), srcName, dstName)
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if summary.Files != 1 || summary.Failed != 0 || len(results) != 1 {
+					t.Fatalf("summary %+v, results %+v", summary, results)
+				}
+
+				r := results[0]
+
+				if r.Resumed != tt.wantResumed {
+					t.Errorf("Resumed = %d, want %d", r.Resumed, tt.wantResumed)
+				}
+
+				if r.Size != int64(len(src))-tt.wantResumed {
+					t.Errorf("Size = %d, want %d", r.Size, int64(len(src))-tt.wantResumed)
+				}
+
+				got, err := ioutil.ReadFile(dstName)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if bytes.Equal(got, src) == false {
+					t.Errorf("destination is not a copy of the source (%d bytes)", len(got))
+				}
+
+				if _, err := os.Stat(dstName + PartialSuffix); os.IsNotExist(err) == false {
+					t.Errorf("partial file was not removed: %v", err)
+				}
+			})
+		}
+	}
+}
+
+const (
+	testChunkSize = 1000
+	testChunkData = 10*testChunkSize + 500
+)
+
+var errTestChunk = errors.New("chunk failed")
+
+// failingChunk makes the copy of the chunk at off fail, but only after the
+// chunk that follows it is copied, so that a later chunk is complete when
+// the copy fails.
+type failingChunk struct {
+	off  int64
+	next chan struct{}
+}
+
+func newFailingChunk(off int64) *failingChunk {
+	return &failingChunk{off: off, next: make(chan struct{})}
+}
+
+// before is called before the chunk at off is copied.
+func (c *failingChunk) before(off int64) error {
+	if off != c.off {
+		return nil
+	}
+
+	select {
+	case <-c.next:
+	case <-time.After(10 * time.Second):
+	}
+
+	return errTestChunk
+}
+
+// after is called after the chunk at off is copied.
+func (c *failingChunk) after(off int64) {
+	if off == c.off+testChunkSize {
+		close(c.next)
+	}
+}
+
+type failingReaderAt struct {
+	*bytes.Reader
+	*failingChunk
+}
+
+func (r failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.before(off); err != nil {
+		return 0, err
+	}
+
+	defer r.after(off)
+
+	return r.Reader.ReadAt(p, off)
+}
+
+type failingFile struct {
+	*os.File
+	*failingChunk
+}
+
+func (f failingFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.before(off); err != nil {
+		return 0, err
+	}
+
+	defer f.after(off)
+
+	return f.File.WriteAt(p, off)
+}
+
+// copyRemote copies src from offset with WriteRemote or ReadRemote. The
+// destination is the file name, which is created with the contents dst.
+func copyRemote(t *testing.T, tr *Transfer, upload bool, src io.ReaderAt, name string, dst []byte, fail *failingChunk, offset int64) (int64, error) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(name, dst, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if upload {
+		if fail != nil {
+			src = failingReaderAt{src.(*bytes.Reader), fail}
+		}
+
+		return tr.WriteRemote(context.Background(), name, src, offset, testChunkData)
+	}
+
+	// src is the remote file
+	srcName := name + ".src"
+
+	b := make([]byte, testChunkData)
+
+	if _, err := src.ReadAt(b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(srcName, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	var w io.WriterAt = f
+
+	if fail != nil {
+		w = failingFile{f, fail}
+	}
+
+	return tr.ReadRemote(context.Background(), srcName, w, offset, testChunkData)
+}
+
+func TestCopyChunks(t *testing.T) {
+	src := testData(testChunkData)
+
+	for _, upload := range []bool{true, false} {
+		for _, streams := range []int{1, 3} {
+			t.Run(fmt.Sprintf("upload=%v/streams=%d", upload, streams), func(t *testing.T) {
+				tr := newTestParallelTransfer(t, streams, testChunkSize)
+
+				name := filepath.Join(t.TempDir(), "dst")
+
+				n, err := copyRemote(t, tr, upload, bytes.NewReader(src), name, nil, nil, 0)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if n != testChunkData {
+					t.Errorf("copied %d bytes, want %d", n, testChunkData)
+				}
+
+				checkTestFile(t, name, src)
+			})
+		}
+	}
+}
+
+// A failed copy is truncated after the last chunk that was complete from
+// the start, even if later chunks were copied. Copying again from there
+// gives the whole file.
+func TestCopyChunksFailure(t *testing.T) {
+	src := testData(testChunkData)
+
+	const failOff = 4 * testChunkSize
+
+	for _, upload := range []bool{true, false} {
+		t.Run(fmt.Sprintf("upload=%v", upload), func(t *testing.T) {
+			tr := newTestParallelTransfer(t, 3, testChunkSize)
+
+			name := filepath.Join(t.TempDir(), "dst")
+
+			_, err := copyRemote(t, tr, upload, bytes.NewReader(src), name, nil, newFailingChunk(failOff), 0)
+
+			if errors.Is(err, errTestChunk) == false {
+				t.Fatalf("err = %v, want %v", err, errTestChunk)
+			}
+
+			checkTestFile(t, name, src[:failOff])
+
+			got, err := ioutil.ReadFile(name)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := copyRemote(t, tr, upload, bytes.NewReader(src), name, got, nil, int64(len(got)))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != testChunkData-failOff {
+				t.Errorf("resumed copy: copied %d bytes, want %d", n, testChunkData-failOff)
+			}
+
+			checkTestFile(t, name, src)
+		})
+	}
+}
+
+// Chunks are counted from the offset of a resumed copy, and the bytes
+// before it are not copied again.
+func TestCopyChunksOffset(t *testing.T) {
+	src := testData(testChunkData)
+
+	const offset = 2*testChunkSize + 300
+
+	for _, upload := range []bool{true, false} {
+		t.Run(fmt.Sprintf("upload=%v", upload), func(t *testing.T) {
+			tr := newTestParallelTransfer(t, 3, testChunkSize)
+
+			name := filepath.Join(t.TempDir(), "dst")
+
+			// The existing start of the destination is kept
+			existing := bytes.Repeat([]byte("x"), offset)
+
+			n, err := copyRemote(t, tr, upload, bytes.NewReader(src), name, existing, nil, offset)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != testChunkData-offset {
+				t.Errorf("copied %d bytes, want %d", n, testChunkData-offset)
+			}
+
+			checkTestFile(t, name, append(existing, src[offset:]...))
+
+			// A failure is truncated to a chunk boundary from the offset
+			_, err = copyRemote(t, tr, upload, bytes.NewReader(src), name, existing, newFailingChunk(offset+testChunkSize), offset)
+
+			if errors.Is(err, errTestChunk) == false {
+				t.Fatalf("err = %v, want %v", err, errTestChunk)
+			}
+
+			checkTestFile(t, name, append(existing, src[offset:offset+testChunkSize]...))
+		})
+	}
+}
+
+func checkTestFile(t *testing.T, name string, want []byte) {
+	t.Helper()
+
+	got, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(got, want) == false {
+		t.Errorf("%s has %d bytes, want %d bytes", filepath.Base(name), len(got), len(want))
+	}
+}
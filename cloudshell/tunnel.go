@@ -0,0 +1,376 @@
+package cloudshell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//******************************************************************************************
+// Tunnels
+//
+// A Tunnel shares one SSH connection between many forwarded connections.
+// Cloud Shell stops an idle environment and may move it to another VM when
+// it is started again, so when the connection is lost the Tunnel waits for
+// the environment to be running, refreshes its SSH host and port, and
+// reconnects on the next use.
+//******************************************************************************************
+
+// Tunnel is a reconnecting SSH connection for port forwarding. It is safe
+// for concurrent use.
+type Tunnel struct {
+	c *Client
+
+	mu     sync.Mutex
+	env    Environment
+	conn   *ssh.Client
+	closed bool
+}
+
+// NewTunnel returns a Tunnel to a running environment. No connection is
+// opened until the first call to Dial or SSHClient.
+func (c *Client) NewTunnel(env Environment) *Tunnel {
+	return &Tunnel{c: c, env: env}
+}
+
+// Environment returns the environment the Tunnel last connected to.
+func (t *Tunnel) Environment() Environment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.env
+}
+
+// SSHClient returns the current SSH connection, connecting or reconnecting
+// first if necessary.
+func (t *Tunnel) SSHClient(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed == true {
+		return nil, errors.New("cloudshell: tunnel is closed")
+	}
+
+	if t.conn != nil {
+		return t.conn, nil
+	}
+
+	conn, err := t.c.Dial(ctx, t.env)
+
+	if err != nil {
+		// The environment may have been stopped or moved
+		t.c.logf("Tunnel: %v, waiting for the environment", err)
+
+		env, werr := t.c.WaitUntilRunning(ctx)
+
+		if werr != nil {
+			return nil, werr
+		}
+
+		t.env = env
+
+		conn, err = t.c.Dial(ctx, t.env)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.conn = conn
+
+	go func() {
+		err := conn.Wait()
+
+		t.c.logf("Tunnel: connection closed: %v", err)
+
+		t.mu.Lock()
+
+		if t.conn == conn {
+			t.conn = nil
+		}
+
+		t.mu.Unlock()
+	}()
+
+	return conn, nil
+}
+
+// Dial opens a connection to addr from the environment, for example
+// "localhost:8080". If the SSH connection was lost, Dial reconnects once.
+func (t *Tunnel) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := t.SSHClient(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		remote, err := conn.Dial(network, addr)
+
+		if err == nil {
+			return remote, nil
+		}
+
+		// A refused channel means nothing is listening on addr. Any
+		// other error is a broken SSH connection.
+		var open *ssh.OpenChannelError
+
+		if errors.As(err, &open) || attempt != 0 {
+			return nil, err
+		}
+
+		t.reset(conn)
+	}
+}
+
+// reset drops the SSH connection so that the next use reconnects.
+func (t *Tunnel) reset(conn *ssh.Client) {
+	t.mu.Lock()
+
+	if t.conn == conn {
+		t.conn = nil
+	}
+
+	t.mu.Unlock()
+
+	conn.Close()
+}
+
+// Close closes the SSH connection. Connections opened by Dial are not
+// closed.
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+
+	if t.conn == nil {
+		return nil
+	}
+
+	err := t.conn.Close()
+	t.conn = nil
+
+	return err
+}
+
+//******************************************************************************************
+// Forwarding specifications
+//******************************************************************************************
+
+// Forward is a port forwarding specification in the format of ssh -L and
+// ssh -R: [bind_address:]port:host:hostport
+type Forward struct {
+	BindAddress string
+	Port        int
+	Host        string
+	HostPort    int
+}
+
+// ListenAddress returns bind_address:port.
+func (f Forward) ListenAddress() string {
+	return net.JoinHostPort(f.BindAddress, strconv.Itoa(f.Port))
+}
+
+// TargetAddress returns host:hostport.
+func (f Forward) TargetAddress() string {
+	return net.JoinHostPort(f.Host, strconv.Itoa(f.HostPort))
+}
+
+func (f Forward) String() string {
+	return f.ListenAddress() + " -> " + f.TargetAddress()
+}
+
+// ParseForward parses [bind_address:]port:host:hostport. The short form
+// "port" forwards the port to the same port on localhost. IPv6 addresses
+// are written in brackets. bind_address defaults to localhost.
+func ParseForward(s string) (Forward, error) {
+	fields := splitForward(s)
+
+	f := Forward{BindAddress: "localhost"}
+
+	var port, hostPort string
+
+	switch len(fields) {
+	case 1:
+		port, f.Host, hostPort = fields[0], "localhost", fields[0]
+
+	case 3:
+		port, f.Host, hostPort = fields[0], fields[1], fields[2]
+
+	case 4:
+		f.BindAddress, port, f.Host, hostPort = fields[0], fields[1], fields[2], fields[3]
+
+	default:
+		return f, fmt.Errorf("cloudshell: invalid forward %q, expected [bind_address:]port:host:hostport", s)
+	}
+
+	var err error
+
+	f.Port, err = strconv.Atoi(port)
+
+	if err != nil || f.Port < 0 || f.Port > 65535 {
+		return f, fmt.Errorf("cloudshell: invalid port %q in forward %q", port, s)
+	}
+
+	f.HostPort, err = strconv.Atoi(hostPort)
+
+	if err != nil || f.HostPort <= 0 || f.HostPort > 65535 {
+		return f, fmt.Errorf("cloudshell: invalid port %q in forward %q", hostPort, s)
+	}
+
+	if f.Host == "" {
+		return f, fmt.Errorf("cloudshell: missing host in forward %q", s)
+	}
+
+	return f, nil
+}
+
+// splitForward splits s at colons that are not inside brackets and removes
+// the brackets.
+func splitForward(s string) []string {
+	var fields []string
+
+	depth := 0
+	start := 0
+
+	for x := 0; x < len(s); x++ {
+		switch s[x] {
+		case '[':
+			depth++
+
+		case ']':
+			depth--
+
+		case ':':
+			if depth == 0 {
+				fields = append(fields, s[start:x])
+				start = x + 1
+			}
+		}
+	}
+
+	fields = append(fields, s[start:])
+
+	for x := range fields {
+		fields[x] = strings.TrimSuffix(strings.TrimPrefix(fields[x], "["), "]")
+	}
+
+	return fields
+}
+
+//******************************************************************************************
+// Remote forwarding
+//******************************************************************************************
+
+// tunnelListener is a listener in the environment that is opened again on
+// the new connection when the Tunnel reconnects.
+type tunnelListener struct {
+	t    *Tunnel
+	ctx  context.Context
+	addr string
+
+	mu       sync.Mutex
+	conn     *ssh.Client
+	listener net.Listener
+	closed   bool
+}
+
+// Listen asks the SSH server of the environment to listen on addr, for
+// example "localhost:9000" (tcpip-forward), and returns a listener for the
+// connections it accepts. When the SSH connection is lost, Accept
+// reconnects and listens again on the same port.
+func (t *Tunnel) Listen(ctx context.Context, addr string) (net.Listener, error) {
+	l := &tunnelListener{t: t, ctx: ctx, addr: addr}
+
+	err := l.listen()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *tunnelListener) listen() error {
+	conn, err := l.t.SSHClient(l.ctx)
+
+	if err != nil {
+		return err
+	}
+
+	listener, err := conn.Listen("tcp", l.addr)
+
+	if err != nil {
+		return fmt.Errorf("cloudshell: listen on %s: %w", l.addr, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed == true {
+		listener.Close()
+		return net.ErrClosed
+	}
+
+	// Keep the port the server picked for port 0
+	l.addr = listener.Addr().String()
+	l.conn = conn
+	l.listener = listener
+
+	return nil
+}
+
+func (l *tunnelListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		conn, listener := l.conn, l.listener
+		l.mu.Unlock()
+
+		c, err := listener.Accept()
+
+		if err == nil {
+			return c, nil
+		}
+
+		l.mu.Lock()
+		closed := l.closed
+		l.mu.Unlock()
+
+		if closed == true {
+			return nil, net.ErrClosed
+		}
+
+		l.t.c.logf("Tunnel: remote listener %s: %v, reconnecting", l.addr, err)
+
+		l.t.reset(conn)
+
+		err = l.listen()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *tunnelListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+
+	return l.listener.Close()
+}
+
+func (l *tunnelListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.listener.Addr()
+}
@@ -0,0 +1,112 @@
+package cloudshell
+
+import "testing"
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec   string
+		want   Forward
+		listen string
+		target string
+	}{
+		{"8080", Forward{"localhost", 8080, "localhost", 8080}, "localhost:8080", "localhost:8080"},
+		{"8080:localhost:80", Forward{"localhost", 8080, "localhost", 80}, "localhost:8080", "localhost:80"},
+		{"8080:example.com:443", Forward{"localhost", 8080, "example.com", 443}, "localhost:8080", "example.com:443"},
+		{"0.0.0.0:8080:localhost:80", Forward{"0.0.0.0", 8080, "localhost", 80}, "0.0.0.0:8080", "localhost:80"},
+		{"*:8080:localhost:80", Forward{"*", 8080, "localhost", 80}, "*:8080", "localhost:80"},
+		{":8080:localhost:80", Forward{"", 8080, "localhost", 80}, ":8080", "localhost:80"},
+
+		// IPv6 addresses in brackets
+		{"[::1]:8080:localhost:80", Forward{"::1", 8080, "localhost", 80}, "[::1]:8080", "localhost:80"},
+		{"8080:[::1]:80", Forward{"localhost", 8080, "::1", 80}, "localhost:8080", "[::1]:80"},
+		{"[fe80::1%eth0]:8080:[2001:db8::1]:80", Forward{"fe80::1%eth0", 8080, "2001:db8::1", 80}, "[fe80::1%eth0]:8080", "[2001:db8::1]:80"},
+
+		// Port 0 listens on any free port, for example with -R
+		{"0:localhost:3000", Forward{"localhost", 0, "localhost", 3000}, "localhost:0", "localhost:3000"},
+		{"65535:localhost:65535", Forward{"localhost", 65535, "localhost", 65535}, "localhost:65535", "localhost:65535"},
+
+		// -R specs: the port is in the environment, the target is local
+		{"9000:localhost:3000", Forward{"localhost", 9000, "localhost", 3000}, "localhost:9000", "localhost:3000"},
+		{"0.0.0.0:9000:127.0.0.1:3000", Forward{"0.0.0.0", 9000, "127.0.0.1", 3000}, "0.0.0.0:9000", "127.0.0.1:3000"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseForward(tt.spec)
+
+		if err != nil {
+			t.Errorf("ParseForward(%q): %v", tt.spec, err)
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("ParseForward(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+
+		if got.ListenAddress() != tt.listen || got.TargetAddress() != tt.target {
+			t.Errorf("ParseForward(%q): %s -> %s, want %s -> %s", tt.spec, got.ListenAddress(), got.TargetAddress(), tt.listen, tt.target)
+		}
+	}
+}
+
+func TestParseForwardInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"http",
+		"8080:localhost",
+		"a:b:c:d:e",
+		"8080:localhost:80:90",
+
+		// Ports out of range
+		"-1:localhost:80",
+		"65536:localhost:80",
+		"8080:localhost:0",
+		"8080:localhost:65536",
+		"0",
+		"x:localhost:80",
+		"8080:localhost:http",
+		"8080:localhost:",
+
+		// Missing host
+		"8080::80",
+		"8080:[]:80",
+
+		// IPv6 without brackets or with unbalanced brackets
+		"::1:8080:localhost:80",
+		"[::1:8080:localhost:80",
+		"8080:[::1:80",
+	} {
+		if f, err := ParseForward(spec); err == nil {
+			t.Errorf("ParseForward(%q) = %+v, want an error", spec, f)
+		}
+	}
+}
+
+func TestSplitForward(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{""}},
+		{"8080", []string{"8080"}},
+		{"a:b:c", []string{"a", "b", "c"}},
+		{"[::1]:80", []string{"::1", "80"}},
+		{"[a:b]:[c:d]:e", []string{"a:b", "c:d", "e"}},
+		{"::", []string{"", "", ""}},
+	}
+
+	for _, tt := range tests {
+		got := splitForward(tt.s)
+
+		if len(got) != len(tt.want) {
+			t.Errorf("splitForward(%q) = %q, want %q", tt.s, got, tt.want)
+			continue
+		}
+
+		for x := range got {
+			if got[x] != tt.want[x] {
+				t.Errorf("splitForward(%q) = %q, want %q", tt.s, got, tt.want)
+				break
+			}
+		}
+	}
+}
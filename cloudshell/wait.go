@@ -0,0 +1,180 @@
+package cloudshell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultWaitTimeout is the default of Options.WaitTimeout.
+const DefaultWaitTimeout = 5 * time.Minute
+
+const (
+	waitInitialBackoff = 500 * time.Millisecond
+	waitMaxBackoff     = 10 * time.Second
+	probeTimeout       = 15 * time.Second
+)
+
+// ErrWaitTimeout is returned by WaitUntilRunning when the environment is not
+// ready before the wait timeout.
+var ErrWaitTimeout = errors.New("cloudshell: timed out waiting for the environment")
+
+// waiter tracks the state reported by the start operation and environment
+// while WaitUntilRunning polls them.
+type waiter struct {
+	c     *Client
+	state string
+}
+
+func (w *waiter) report(state string) {
+	if state == "" || state == w.state {
+		return
+	}
+
+	from := w.state
+	w.state = state
+
+	if w.c.opts.OnStateChange != nil {
+		w.c.opts.OnStateChange(from, state)
+	}
+}
+
+// WaitUntilRunning starts the environment if it is not running and waits
+// until it accepts SSH connections.
+//
+// The start operation and the environment are polled with exponential
+// backoff. Once the environment reports RUNNING, it is only declared ready
+// after an SSH handshake to SshHost:SshPort succeeds, including when it is
+// already RUNNING on the first request.
+func (c *Client) WaitUntilRunning(ctx context.Context) (Environment, error) {
+	timeout := c.opts.WaitTimeout
+
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	w := &waiter{c: c}
+
+	env, err := c.GetEnvironment(ctx)
+
+	if err != nil {
+		return env, err
+	}
+
+	w.report(env.State)
+
+	var op *Operation
+
+	if env.State != StateRunning {
+		op, err = c.Start(ctx, nil)
+
+		if err != nil {
+			return env, err
+		}
+	}
+
+	backoff := waitInitialBackoff
+
+	for {
+		if env.State == StateRunning && env.SshHost != "" {
+			err = c.probe(ctx, env)
+
+			if err == nil {
+				return env, nil
+			}
+
+			// A missing key or a host key mismatch will not go away by waiting
+			if isAuthError(err) {
+				return env, err
+			}
+
+			c.logf("SSH not ready: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return env, w.timeout(timeout)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+
+		if backoff > waitMaxBackoff {
+			backoff = waitMaxBackoff
+		}
+
+		//************************************************************
+		// Follow the start operation until it is done. If the
+		// operation cannot be polled, fall back to the environment.
+		//************************************************************
+
+		if op != nil && op.Done == false && op.Name != "" {
+			op, err = c.GetOperation(ctx, op.Name)
+
+			if err != nil {
+				if ctx.Err() != nil {
+					return env, w.timeout(timeout)
+				}
+
+				c.logf("Cannot poll operation: %v", err)
+				op = nil
+			}
+		}
+
+		if op != nil && op.Done == true && op.Error != nil {
+			return env, op.Error
+		}
+
+		//************************************************************
+		//
+		//************************************************************
+
+		env, err = c.GetEnvironment(ctx)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return env, w.timeout(timeout)
+			}
+
+			return env, err
+		}
+
+		w.report(env.State)
+	}
+}
+
+// isAuthError reports whether a Dial error is caused by the SSH credentials
+// or host key rather than by the server not being ready.
+func isAuthError(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrAgentNotRunning) || errors.Is(err, ErrHostKeyMismatch) || errors.Is(err, ErrHostKeyUnknown)
+}
+
+func (w *waiter) timeout(timeout time.Duration) error {
+	return fmt.Errorf("%w after %s, last state: %s", ErrWaitTimeout, timeout, w.state)
+}
+
+// probe performs an SSH handshake with the environment.
+func (c *Client) probe(ctx context.Context, env Environment) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	connection, err := c.Dial(ctx, env)
+
+	// The server completed the handshake and rejected the key, so SSH is
+	// up. A key that was just registered may not be installed yet, see
+	// WaitForKey.
+	if err != nil && strings.Contains(err.Error(), "ssh: unable to authenticate") {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return connection.Close()
+}
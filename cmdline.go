@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
 // Commands for this program
@@ -14,7 +19,6 @@ const (
 	CMD_INFO
 	CMD_PUTTY
 	CMD_SSH
-	CMD_INLINE_SSH
 	CMD_WINSSH
 	CMD_EXEC
 	CMD_UPLOAD
@@ -23,6 +27,13 @@ const (
 	CMD_WINSCP
 	CMD_BENCHMARK_DOWNLOAD
 	CMD_BENCHMARK_UPLOAD
+	CMD_KEYS
+	CMD_HOSTKEYS
+	CMD_FORWARD
+	CMD_SOCKS
+	CMD_PREVIEW
+	CMD_RUN
+	CMD_SYNC
 )
 
 func process_cmdline() {
@@ -34,6 +45,11 @@ func process_cmdline() {
 	}
 
 	for _, arg := range os.Args {
+		// The arguments of a remote command or script are not options
+		if arg == "--" || arg == "run" {
+			break
+		}
+
 		if arg == "-help" || arg == "--help" {
 			cmd_help()
 			os.Exit(0)
@@ -50,6 +66,26 @@ func process_cmdline() {
 			os.Exit(0)
 		}
 
+		// The script and its arguments are passed unchanged, even
+		// if they start with "-": run deploy.sh -n --env prod
+		if arg == "run" && len(args) == 0 {
+			args = append(args, os.Args[x:]...)
+			break
+		}
+
+		// The remaining arguments are the remote command and its
+		// arguments: exec -- cmd arg1 arg2
+		if arg == "--" {
+			config.ExecArgs = os.Args[x + 1:]
+
+			if len(config.ExecArgs) == 0 {
+				fmt.Println("Error: expected a remote command after --")
+				os.Exit(1)
+			}
+
+			break
+		}
+
 		if arg == "-debug" || arg == "--debug" {
 			config.Debug = true
 			continue
@@ -94,6 +130,229 @@ func process_cmdline() {
 			continue
 		}
 
+		if v, ok := get_option_value(&x, "wait-timeout"); ok {
+			d, err := time.ParseDuration(v)
+
+			if err != nil || d <= 0 {
+				fmt.Println("Error: Invalid duration to --wait-timeout: " + v)
+				os.Exit(1)
+			}
+
+			config.Flags.WaitTimeout = d
+			continue
+		}
+
+		if arg == "-ephemeral-key" || arg == "--ephemeral-key" {
+			config.Flags.EphemeralKey = true
+			continue
+		}
+
+		// Port forwards as in ssh -L and ssh -R
+		if arg == "-L" || arg == "-R" {
+			if x == len(os.Args) - 1 {
+				fmt.Println("Error: Missing forward to " + arg)
+				os.Exit(1)
+			}
+
+			x++
+
+			f, err := cloudshell.ParseForward(os.Args[x])
+
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+
+			if arg == "-L" {
+				config.Forwards = append(config.Forwards, f)
+			} else {
+				config.RemoteForwards = append(config.RemoteForwards, f)
+			}
+
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "env"); ok {
+			if strings.Contains(v, "=") == false {
+				fmt.Println("Error: Expected KEY=value to --env: " + v)
+				os.Exit(1)
+			}
+
+			config.ExecEnv = append(config.ExecEnv, v)
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "cwd"); ok {
+			config.ExecDir = v
+			continue
+		}
+
+		if arg == "-r" || arg == "--recursive" {
+			config.Flags.Recursive = true
+			continue
+		}
+
+		if arg == "-delete" || arg == "--delete" {
+			config.Flags.Delete = true
+			continue
+		}
+
+		if arg == "-n" || arg == "-dry-run" || arg == "--dry-run" {
+			config.Flags.DryRun = true
+			continue
+		}
+
+		if arg == "-checksum" || arg == "--checksum" {
+			config.Flags.Checksum = true
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "include"); ok {
+			if err := sync_filter().Include(v); err != nil {
+				fmt.Println("Error: Invalid pattern to --include: " + v)
+				os.Exit(1)
+			}
+
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "exclude"); ok {
+			if err := sync_filter().Exclude(v); err != nil {
+				fmt.Println("Error: Invalid pattern to --exclude: " + v)
+				os.Exit(1)
+			}
+
+			continue
+		}
+
+		if arg == "-verify" || arg == "--verify" {
+			config.Flags.Verify = true
+			continue
+		}
+
+		if arg == "-resume" || arg == "--resume" {
+			config.Flags.Resume = true
+			continue
+		}
+
+		if arg == "-tty" || arg == "--tty" {
+			config.Flags.Tty = true
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "parallel"); ok {
+			n, err := strconv.Atoi(v)
+
+			if err != nil || n < 1 || n > 64 {
+				fmt.Println("Error: Invalid number to --parallel (1 to 64): " + v)
+				os.Exit(1)
+			}
+
+			config.Parallel = n
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "chunk-size"); ok {
+			n, err := parse_size(v)
+
+			if err != nil || n < 32 * 1024 {
+				fmt.Println("Error: Invalid size to --chunk-size (at least 32K): " + v)
+				os.Exit(1)
+			}
+
+			config.ChunkSize = n
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "timeout"); ok {
+			d, err := time.ParseDuration(v)
+
+			if err != nil || d <= 0 {
+				fmt.Println("Error: Invalid duration to --timeout: " + v)
+				os.Exit(1)
+			}
+
+			config.Flags.Timeout = d
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "listen"); ok {
+			if _, _, err := net.SplitHostPort(v); err != nil {
+				fmt.Println("Error: Invalid address to --listen: " + v)
+				os.Exit(1)
+			}
+
+			config.SocksListen = v
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "socks-user"); ok {
+			config.SocksUser = v
+			continue
+		}
+
+		if arg == "-all" || arg == "--all" {
+			config.Flags.All = true
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "passphrase-command"); ok {
+			config.PassphraseCommand = v
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "auth-order"); ok {
+			order, err := cloudshell.ParseAuthOrder(v)
+
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+
+			config.AuthOrder = order
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "host-key-policy"); ok {
+			if v != cloudshell.HostKeyTOFU && v != cloudshell.HostKeyStrict && v != cloudshell.HostKeyInsecure {
+				fmt.Println("Error: Unknown host key policy: " + v + " (tofu, strict, insecure)")
+				os.Exit(1)
+			}
+
+			config.HostKeyPolicy = v
+			continue
+		}
+
+		if arg == "-force" || arg == "--force" {
+			config.Flags.Force = true
+			continue
+		}
+
+		if arg == "-passphrase" || arg == "--passphrase" {
+			config.Flags.Passphrase = true
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "ppk-version"); ok {
+			if v != "2" && v != "3" {
+				fmt.Println("Error: Invalid PPK version: " + v + " (2, 3)")
+				os.Exit(1)
+			}
+
+			config.PpkVersion, _ = strconv.Atoi(v)
+			continue
+		}
+
+		if v, ok := get_option_value(&x, "type"); ok {
+			if v != cloudshell.KeyTypeEd25519 && v != cloudshell.KeyTypeRSA {
+				fmt.Println("Error: Unknown key type: " + v + " (ed25519, rsa)")
+				os.Exit(1)
+			}
+
+			config.KeyType = v
+			continue
+		}
+
 		// WINSCP args
 		if strings.HasPrefix(arg, "/rawsettings") {
 			// config.sshFlags = append(config.sshFlags, os.Args[x:]...)
@@ -101,7 +360,8 @@ func process_cmdline() {
 			break
 		}
 
-		if strings.HasPrefix(arg, "-") {
+		// "-" is standard input
+		if strings.HasPrefix(arg, "-") && arg != "-" {
 			fmt.Println("Error: Unknown option: " + arg)
 			os.Exit(1)
 		}
@@ -134,11 +394,7 @@ func process_cmdline() {
 			}
 
 		case "ssh":
-			if isWindows() == true {
-				config.Command = CMD_INLINE_SSH
-			} else {
-				config.Command = CMD_SSH
-			}
+			config.Command = CMD_SSH
 
 		case "bitvise":
 			if isWindows() == true {
@@ -165,15 +421,32 @@ func process_cmdline() {
 			}
 
 		case "exec":
-			if len(args) < 2 {
+			config.Command = CMD_EXEC
+
+			if len(config.ExecArgs) != 0 {
+				config.RemoteCommand = cloudshell.ShellQuote(config.ExecArgs...)
+				break
+			}
+
+			if x == len(args) - 1 {
 				fmt.Println("Error: expected a remote command")
 				os.Exit(1)
 			}
 
-			config.Command = CMD_EXEC
 			config.RemoteCommand = args[x + 1]
 			x++
 
+		case "run":
+			if x == len(args) - 1 {
+				fmt.Println("Error: expected a script file name, or - for standard input")
+				os.Exit(1)
+			}
+
+			config.Command = CMD_RUN
+			config.ScriptFile = args[x + 1]
+			config.ScriptArgs = args[x + 2:]
+			x = len(args)
+
 		case "download":
 			if len(args) < 2 {
 				fmt.Println("Error: expected a source file name")
@@ -181,20 +454,18 @@ func process_cmdline() {
 			}
 
 			config.Command = CMD_DOWNLOAD
-			config.SrcFile = strings.ReplaceAll(args[x + 1], "\\", "/")
-			x++
 
-			if len(args) >= 3 {
-				config.DstFile = strings.ReplaceAll(args[x + 1], "\\", "/")
-				x++
-			} else {
-				_, file := path.Split(config.SrcFile)
+			files := transfer_args(args[x + 1:])
 
-				config.DstFile = file
+			for _, file := range files[:len(files) - 1] {
+				config.SrcFiles = append(config.SrcFiles, strings.ReplaceAll(file, "\\", "/"))
 			}
 
+			config.DstFile = files[len(files) - 1]
+			x = len(args)
+
 			if config.Debug == true {
-				fmt.Println("SrcFile:", config.SrcFile)
+				fmt.Println("SrcFiles:", config.SrcFiles)
 				fmt.Println("DstFile:", config.DstFile)
 			}
 
@@ -204,31 +475,146 @@ func process_cmdline() {
 				os.Exit(1)
 			}
 
-			path, err := filepath.Abs(args[x + 1])
+			config.Command = CMD_UPLOAD
 
-			if err != nil {
-				fmt.Println(err)
+			files := transfer_args(args[x + 1:])
+
+			for _, file := range files[:len(files) - 1] {
+				path, err := filepath.Abs(file)
+
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+
+				config.SrcFiles = append(config.SrcFiles, path)
+			}
+
+			config.DstFile = strings.ReplaceAll(files[len(files) - 1], "\\", "/")
+			x = len(args)
+
+			if config.Debug == true {
+				fmt.Println("SrcFiles:", config.SrcFiles)
+				fmt.Println("DstFile:", config.DstFile)
+			}
+
+		case "sync":
+			if len(args) - x != 3 {
+				fmt.Println("Error: expected a source and a destination directory, for example: sync ./dir :dir")
+				os.Exit(1)
+			}
+
+			src, dst := args[x + 1], args[x + 2]
+
+			// The remote side starts with ":"
+			if strings.HasPrefix(src, ":") == strings.HasPrefix(dst, ":") {
+				fmt.Println("Error: one directory must be in Cloud Shell, written as :dir")
+				os.Exit(1)
+			}
+
+			config.Command = CMD_SYNC
+			config.SyncUpload = strings.HasPrefix(dst, ":")
+
+			if config.SyncUpload == true {
+				config.SyncSrc, config.SyncDst = src, sync_remote_dir(dst)
+			} else {
+				config.SyncSrc, config.SyncDst = sync_remote_dir(src), dst
+			}
+
+			x = len(args)
+
+		case "keys":
+			if x == len(args) - 1 {
+				fmt.Println("Error: expected a sub command (init, list, add, remove, rotate, export-ppk)")
 				os.Exit(1)
 			}
 
-			config.Command = CMD_UPLOAD
-			config.SrcFile = path
 			x++
 
-			if len(args) >= 3 {
-				config.DstFile = strings.ReplaceAll(args[x + 1], "\\", "/")
+			switch args[x] {
+			case "init", "list", "rotate":
+				config.Command = CMD_KEYS
+				config.KeysCommand = args[x]
+
+			case "export-ppk":
+				config.Command = CMD_KEYS
+				config.KeysCommand = args[x]
+
+				if x < len(args) - 1 {
+					config.KeyArg = args[x + 1]
+					x++
+				}
+
+			case "add", "remove":
+				if x == len(args) - 1 {
+					fmt.Println("Error: expected a public key file, public key or fingerprint")
+					os.Exit(1)
+				}
+
+				config.Command = CMD_KEYS
+				config.KeysCommand = args[x]
+				config.KeyArg = args[x + 1]
 				x++
-			} else {
-				file := strings.ReplaceAll(config.SrcFile, "\\", "/")
 
-				_, file = filepath.Split(file)
+			default:
+				fmt.Println("Error: expected a sub command (init, list, add, remove, rotate, export-ppk)")
+				os.Exit(1)
+			}
 
-				config.DstFile = file
+		case "forward":
+			if x == len(args) - 1 && len(config.Forwards) == 0 && len(config.RemoteForwards) == 0 {
+				fmt.Println("Error: expected a forward: [bind_address:]port:host:hostport")
+				os.Exit(1)
 			}
 
-			if config.Debug == true {
-				fmt.Println("SrcFile:", config.SrcFile)
-				fmt.Println("DstFile:", config.DstFile)
+			config.Command = CMD_FORWARD
+
+			for x++; x < len(args); x++ {
+				f, err := cloudshell.ParseForward(args[x])
+
+				if err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+
+				config.Forwards = append(config.Forwards, f)
+			}
+
+		case "socks":
+			config.Command = CMD_SOCKS
+
+		case "preview":
+			config.Command = CMD_PREVIEW
+			config.PreviewPort = default_preview_port
+
+			if x < len(args) - 1 {
+				x++
+
+				port, err := strconv.Atoi(args[x])
+
+				if err != nil || port <= 0 || port > 65535 {
+					fmt.Println("Error: Invalid port: " + args[x])
+					os.Exit(1)
+				}
+
+				config.PreviewPort = port
+			}
+
+		case "hostkeys":
+			config.Command = CMD_HOSTKEYS
+			config.HostKeysCommand = "list"
+
+			if x < len(args) - 1 {
+				x++
+
+				switch args[x] {
+				case "list", "reset":
+					config.HostKeysCommand = args[x]
+
+				default:
+					fmt.Println("Error: expected a sub command (list, reset)")
+					os.Exit(1)
+				}
 			}
 
 		case "benchmark":
@@ -256,6 +642,20 @@ func process_cmdline() {
 				os.Exit(1)
 			}
 
+			// Optional size, for example 1G
+			if x < len(args) - 1 {
+				x++
+
+				n, err := parse_size(args[x])
+
+				if err != nil || n <= 0 {
+					fmt.Println("Error: Invalid benchmark size: " + args[x])
+					os.Exit(1)
+				}
+
+				config.benchmark_size = n
+			}
+
 		default:
 			if config.Command != CMD_NONE {
 				fmt.Println("Error: Unknown command line argument: ", arg)
@@ -270,6 +670,157 @@ func process_cmdline() {
 			os.Exit(1)
 		}
 	}
+
+	if config.Command != CMD_EXEC && config.Command != CMD_RUN {
+		if len(config.ExecArgs) != 0 || len(config.ExecEnv) != 0 || config.ExecDir != "" || config.Flags.Timeout != 0 {
+			fmt.Println("Error: --, --env, --cwd and --timeout are only supported by exec and run")
+			os.Exit(1)
+		}
+	}
+
+	if config.Command != CMD_SYNC {
+		if config.Flags.Delete == true || config.Flags.DryRun == true || config.Flags.Checksum == true || config.SyncFilter != nil {
+			fmt.Println("Error: --delete, --dry-run, --checksum, --include and --exclude are only supported by sync")
+			os.Exit(1)
+		}
+	}
+
+	if config.Flags.Recursive == true && config.Command != CMD_UPLOAD && config.Command != CMD_DOWNLOAD {
+		fmt.Println("Error: -r is only supported by upload and download")
+		os.Exit(1)
+	}
+
+	switch config.Command {
+	case CMD_UPLOAD, CMD_DOWNLOAD, CMD_SYNC, CMD_BENCHMARK_DOWNLOAD, CMD_BENCHMARK_UPLOAD:
+
+	default:
+		if config.Parallel != 0 || config.ChunkSize != 0 {
+			fmt.Println("Error: --parallel and --chunk-size are only supported by upload, download, sync and benchmark")
+			os.Exit(1)
+		}
+	}
+
+	if config.Flags.Resume == true && config.Command != CMD_UPLOAD && config.Command != CMD_DOWNLOAD {
+		fmt.Println("Error: --resume is only supported by upload and download")
+		os.Exit(1)
+	}
+
+	if config.Flags.Verify == true && config.Command != CMD_UPLOAD && config.Command != CMD_DOWNLOAD && config.Command != CMD_SYNC {
+		fmt.Println("Error: --verify is only supported by upload, download and sync")
+		os.Exit(1)
+	}
+
+	if config.Command != CMD_EXEC && config.Flags.Tty == true {
+		fmt.Println("Error: --tty is only supported by exec")
+		os.Exit(1)
+	}
+
+	if config.Command != CMD_FORWARD && (len(config.Forwards) != 0 || len(config.RemoteForwards) != 0) {
+		fmt.Println("Error: -L and -R are only supported by forward")
+		os.Exit(1)
+	}
+
+	if config.Flags.EphemeralKey == true {
+		switch config.Command {
+		case CMD_SSH, CMD_EXEC, CMD_RUN, CMD_UPLOAD, CMD_DOWNLOAD, CMD_SYNC, CMD_BENCHMARK_DOWNLOAD, CMD_BENCHMARK_UPLOAD, CMD_FORWARD, CMD_SOCKS, CMD_PREVIEW:
+			// Supported
+
+		default:
+			fmt.Println("Error: --ephemeral-key is supported by ssh, exec, run, upload, download, sync, benchmark, forward, socks and preview")
+			os.Exit(1)
+		}
+	}
+}
+
+// get_option_value returns the value of an option given as "--name value"
+// or "--name=value" and advances the argument index past the value.
+func get_option_value(x *int, name string) (string, bool) {
+	arg := os.Args[*x]
+
+	if arg == "-" + name || arg == "--" + name {
+		if *x == len(os.Args) - 1 {
+			fmt.Println("Error: Missing value to --" + name)
+			os.Exit(1)
+		}
+
+		*x++
+		return os.Args[*x], true
+	}
+
+	for _, prefix := range []string{"-" + name + "=", "--" + name + "="} {
+		if strings.HasPrefix(arg, prefix) {
+			return arg[len(prefix):], true
+		}
+	}
+
+	return "", false
+}
+
+// sync_filter returns the filter of the sync command, creating it with the
+// ignore files that are read in each directory.
+func sync_filter() *cloudshell.Filter {
+	if config.SyncFilter == nil {
+		config.SyncFilter = cloudshell.NewFilter(sync_ignore_files...)
+	}
+
+	return config.SyncFilter
+}
+
+// sync_remote_dir removes the ":" of a remote directory. ":" alone is the
+// home directory.
+func sync_remote_dir(dir string) string {
+	dir = strings.ReplaceAll(dir[1:], "\\", "/")
+
+	if dir == "" {
+		return "."
+	}
+
+	return dir
+}
+
+// parse_size parses a number of bytes with an optional K, M or G suffix
+// (powers of 1024), for example 8M.
+func parse_size(s string) (int64, error) {
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+
+	multiplier := int64(1)
+
+	switch strings.ToUpper(s[len(s) - 1:]) {
+	case "K":
+		multiplier = 1024
+
+	case "M":
+		multiplier = 1024 * 1024
+
+	case "G":
+		multiplier = 1024 * 1024 * 1024
+	}
+
+	if multiplier != 1 {
+		s = s[:len(s) - 1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n * multiplier, nil
+}
+
+// transfer_args returns the sources and destination of upload and
+// download. The last argument is the destination. With one argument the
+// destination is the current directory, which for Cloud Shell is the home
+// directory.
+func transfer_args(args []string) []string {
+	if len(args) == 1 {
+		return append(args, ".")
+	}
+
+	return args
 }
 
 func cmd_help() {
@@ -284,13 +835,54 @@ func cmd_help() {
 	fmt.Println("  cloudshell winscp                     - connect to Cloud Shell with Windows WinSCP")
 	fmt.Println("  cloudshell bitvise                    - connect to Cloud Shell with Windows Bitvise")
 	fmt.Println("  cloudshell exec \"command\"             - Execute remote command on Cloud Shell")
+	fmt.Println("  cloudshell exec [options] -- cmd args - Execute remote command with arguments, quoted for the shell")
+	fmt.Println("  cloudshell run script [args]          - Run a local script (or - for stdin) in Cloud Shell")
 	fmt.Println("  cloudshell upload src_file dst_file   - Upload local file to Cloud Shell")
+	fmt.Println("  cloudshell upload src... dst_dir      - Upload files or glob patterns to a directory")
 	fmt.Println("  cloudshell download src_file dst_file - Download from Cloud Shell to local file")
-	fmt.Println("  cloudshell benchmark download         - Benchmark download speed from Cloud Shell")
-	fmt.Println("  cloudshell benchmark upload           - Benchmark upload speed from Cloud Shell")
+	fmt.Println("  cloudshell download src... dst_dir    - Download files or glob patterns to a directory")
+	fmt.Println("  cloudshell sync ./dir :dir            - Copy new and changed files of a directory to Cloud Shell")
+	fmt.Println("  cloudshell sync :dir ./dir            - Copy new and changed files of a directory from Cloud Shell")
+	fmt.Println("  cloudshell forward port:host:hostport - Forward a local port to a port in Cloud Shell")
+	fmt.Println("  cloudshell forward -R port:host:hostport - Forward a port in Cloud Shell to a local port")
+	fmt.Println("  cloudshell socks [--listen addr:port] - SOCKS5 proxy through Cloud Shell (default 127.0.0.1:1080)")
+	fmt.Println("  cloudshell preview [port]             - Open a Web Preview of a port in Cloud Shell (default 8080)")
+	fmt.Println("  cloudshell hostkeys [list]            - List the known Cloud Shell host keys")
+	fmt.Println("  cloudshell hostkeys reset [--all]     - Forget the host key of this environment (or all)")
+	fmt.Println("  cloudshell benchmark download [size]  - Benchmark download speed from Cloud Shell")
+	fmt.Println("  cloudshell benchmark upload [size]    - Benchmark upload speed from Cloud Shell")
+	fmt.Println("  cloudshell keys init                  - Create an SSH key and register it with Cloud Shell")
+	fmt.Println("  cloudshell keys list                  - List the public keys registered with Cloud Shell")
+	fmt.Println("  cloudshell keys add key               - Register a public key file or public key")
+	fmt.Println("  cloudshell keys remove key            - Remove a public key, by file, key or SHA256 fingerprint")
+	fmt.Println("  cloudshell keys rotate                - Replace the SSH key with a new key")
+	fmt.Println("  cloudshell keys export-ppk [file]     - Convert the SSH key to a PuTTY .ppk file")
 	fmt.Println("")
 	fmt.Println("--debug - Turn on debug output")
 	fmt.Println("--adc  -  Use Application Default Credentials - Compute Engine only")
 	fmt.Println("--auth  - (re)Authenticate ignoring user_credentials.json")
 	fmt.Println("--login - Specify an email address as a login hint")
+	fmt.Println("--type - Key type for keys init and rotate: ed25519 or rsa")
+	fmt.Println("--force - keys init: replace an existing SSH key")
+	fmt.Println("--ppk-version - PuTTY key file version: 2 (default) or 3")
+	fmt.Println("--passphrase - keys export-ppk: prompt for a passphrase to encrypt the .ppk file")
+	fmt.Println("--passphrase-command - Command that prints the passphrase of an encrypted SSH key")
+	fmt.Println("--auth-order - SSH keys to use, in order: keyfile,agent (default)")
+	fmt.Println("-r, --recursive - upload, download: copy directories")
+	fmt.Println("--resume - upload, download: continue an existing destination file")
+	fmt.Println("--verify - upload, download, sync: compare the SHA-256 checksum of each file after the copy")
+	fmt.Println("--parallel - upload, download, sync, benchmark: copy large files over this many SSH connections")
+	fmt.Println("--chunk-size - upload, download, sync, benchmark: chunk size for --parallel (default 8M)")
+	fmt.Println("--delete - sync: delete files of the destination that are not in the source")
+	fmt.Println("-n, --dry-run - sync: show the changes without making them")
+	fmt.Println("--checksum - sync: compare files by SHA-256 checksum instead of modification time")
+	fmt.Println("--include, --exclude - sync: files to copy or skip (.gitignore patterns), may be repeated")
+	fmt.Println("--env KEY=value - exec, run: set an environment variable, may be repeated")
+	fmt.Println("--cwd - exec, run: remote working directory")
+	fmt.Println("--tty - exec: allocate a pseudo-terminal")
+	fmt.Println("--timeout - exec, run: kill the remote command after this duration, for example 5m")
+	fmt.Println("--socks-user - socks: require this user name, the password is read from CLOUDSHELL_SOCKS_PASSWORD or prompted")
+	fmt.Println("--host-key-policy - tofu (default), strict or insecure")
+	fmt.Println("--ephemeral-key - Use an in-memory SSH key registered for this session only")
+	fmt.Println("--wait-timeout - Maximum time to wait for Cloud Shell to start (default 5m)")
 }
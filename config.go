@@ -6,11 +6,18 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
 type ConfigJson struct {
 	ClientSecretsFile	string   `json:"client_secrets_file"`
 	WinscpFlags       	string   `json:"winscp_flags"`
+	ApiVersion       	string   `json:"api_version"`
+	HostKeyPolicy     	string   `json:"host_key_policy"`
+	AuthOrder         	string   `json:"auth_order"`
+	PassphraseCommand 	string   `json:"passphrase_command"`
 
 }
 
@@ -20,6 +27,19 @@ type FlagsStruct struct {
 	Auth		bool
 	Login		string
 	Info		bool
+	Force		bool
+	Passphrase	bool
+	EphemeralKey	bool
+	Tty		bool
+	Recursive	bool
+	Resume		bool
+	Verify		bool
+	Delete		bool
+	DryRun		bool
+	Checksum	bool
+	Timeout		time.Duration
+	All		bool
+	WaitTimeout	time.Duration
 }
 
 type Config struct {
@@ -32,19 +52,73 @@ type Config struct {
 
 	ClientSecretsFile	string
 
+	// Cloud Shell API version: v1 or v1alpha1
+	ApiVersion		string
+
+	// Host key verification: tofu, strict or insecure
+	HostKeyPolicy		string
+
+	// SSH authentication sources in order of preference: agent, keyfile
+	AuthOrder		[]string
+
+	// Command that prints the passphrase of an encrypted SSH key
+	PassphraseCommand	string
+
 	// Command to execute
 	Command			int
 
 	// Command "exec"
 	RemoteCommand		string
 
-	// Commands "download" and "upload"
-	SrcFile			string
+	// Commands "download" and "upload". Sources may be glob patterns.
+	SrcFiles		[]string
 	DstFile			string
 
+	// Commands "download", "upload" and "benchmark": number of SSH
+	// connections and chunk size for large files
+	Parallel		int
+	ChunkSize		int64
+
 	// Command line global options
 	Flags			FlagsStruct
 
+	// Command "keys"
+	KeysCommand		string
+	KeyType			string
+	KeyArg			string
+
+	// PuTTY .ppk file version: 2 or 3
+	PpkVersion		int
+
+	// Command "exec"
+	ExecArgs		[]string
+	ExecEnv			[]string
+	ExecDir			string
+
+	// Command "run"
+	ScriptFile		string
+	ScriptArgs		[]string
+
+	// Command "forward"
+	Forwards		[]cloudshell.Forward
+	RemoteForwards		[]cloudshell.Forward
+
+	// Command "socks"
+	SocksListen		string
+	SocksUser		string
+
+	// Command "preview"
+	PreviewPort		int
+
+	// Command "sync": one side is remote
+	SyncSrc			string
+	SyncDst			string
+	SyncUpload		bool
+	SyncFilter		*cloudshell.Filter
+
+	// Command "hostkeys"
+	HostKeysCommand		string
+
 	// Commands "benchmark download" and "benchark upload"
 	benchmark_size		int64
 
@@ -76,6 +150,25 @@ func init_config() error {
 	}
 
 	config.ClientSecretsFile = configJson.ClientSecretsFile
+	config.ApiVersion = configJson.ApiVersion
+	config.HostKeyPolicy = configJson.HostKeyPolicy
+	config.PassphraseCommand = configJson.PassphraseCommand
+
+	if configJson.AuthOrder != "" {
+		order, err := cloudshell.ParseAuthOrder(configJson.AuthOrder)
+
+		if err != nil {
+			fmt.Println("Error: config.json auth_order:", err)
+			os.Exit(1)
+		}
+
+		config.AuthOrder = order
+	}
+
+	// PuTTY before 0.75 cannot read version 3
+	config.PpkVersion = 2
+
+	config.SocksListen = "127.0.0.1:1080"
 
 	// fmt.Println("Client Secrets File:", config.ClientSecretsFile)
 
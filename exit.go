@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Exit code of the program, for example the exit status of a remote command
+var exit_code int
+
+func set_exit_code(code int) {
+	exit_code = code
+}
+
+// Functions to run before the program exits, including on Ctrl-C
+var exit_handlers []func()
+var exit_mutex sync.Mutex
+
+func at_exit(f func()) {
+	exit_mutex.Lock()
+	defer exit_mutex.Unlock()
+
+	exit_handlers = append(exit_handlers, f)
+}
+
+// The handlers run once. Another caller, for example Ctrl-C while the
+// handlers run, waits until they are done.
+var exit_once sync.Once
+
+func run_exit_handlers() {
+	exit_once.Do(func() {
+		exit_mutex.Lock()
+		handlers := exit_handlers
+		exit_handlers = nil
+		exit_mutex.Unlock()
+
+		// Run in reverse order of registration
+		for x := len(handlers) - 1; x >= 0; x-- {
+			handlers[x]()
+		}
+	})
+}
+
+func handle_signals() {
+	c := make(chan os.Signal, 1)
+
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-c
+
+		fmt.Fprintln(os.Stderr, "Interrupted")
+
+		run_exit_handlers()
+
+		os.Exit(130)
+	}()
+}
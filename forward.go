@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+// Number of forwarded connections that are open
+var forward_active int64
+
+func cmd_forward(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	//************************************************************
+	// Connect first so that SSH errors are reported before any
+	// local port is opened
+	//************************************************************
+
+	tunnel, err := open_tunnel(ctx, client, params)
+
+	if err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, f := range config.Forwards {
+		listener, err := net.Listen("tcp", f.ListenAddress())
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+
+		at_exit(func() {
+			listener.Close()
+		})
+
+		fmt.Println("Forwarding", listener.Addr(), "to", f.TargetAddress(), "in Cloud Shell")
+
+		target := f.TargetAddress()
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			forward_accept(listener, target, func() (net.Conn, error) {
+				return tunnel.Dial(ctx, "tcp", target)
+			})
+		}()
+	}
+
+	//************************************************************
+	// Remote forwards: Cloud Shell listens and the connections
+	// are made from this computer
+	//************************************************************
+
+	for _, f := range config.RemoteForwards {
+		listener, err := tunnel.Listen(ctx, f.ListenAddress())
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+
+		at_exit(func() {
+			listener.Close()
+		})
+
+		fmt.Println("Forwarding", listener.Addr(), "in Cloud Shell to", f.TargetAddress())
+
+		target := f.TargetAddress()
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			forward_accept(listener, target, func() (net.Conn, error) {
+				var d net.Dialer
+
+				return d.DialContext(ctx, "tcp", target)
+			})
+		}()
+	}
+
+	fmt.Println("Press Ctrl-C to stop")
+
+	wg.Wait()
+}
+
+// open_tunnel opens the SSH connection shared by forwarded connections.
+func open_tunnel(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) (*cloudshell.Tunnel, error) {
+	tunnel := client.NewTunnel(params)
+
+	_, err := tunnel.SSHClient(ctx)
+
+	if err != nil {
+		print_ssh_error(err)
+		return nil, err
+	}
+
+	at_exit(func() {
+		tunnel.Close()
+	})
+
+	return tunnel, nil
+}
+
+// forward_accept accepts connections until the listener is closed and
+// proxies each one to a connection opened by dial.
+func forward_accept(listener net.Listener, target string, dial func() (net.Conn, error)) {
+	for {
+		local, err := listener.Accept()
+
+		if err != nil {
+			if config.Debug == true {
+				fmt.Println("Accept:", err)
+			}
+
+			return
+		}
+
+		go func() {
+			remote, err := dial()
+
+			if err != nil {
+				fmt.Println("Error: Cannot connect to", target + ":", err)
+				local.Close()
+				return
+			}
+
+			active := atomic.AddInt64(&forward_active, 1)
+
+			if config.Debug == true {
+				fmt.Printf("Connection from %s to %s (%d active)\n", local.RemoteAddr(), target, active)
+			}
+
+			proxy_connection(local, remote)
+
+			active = atomic.AddInt64(&forward_active, -1)
+
+			if config.Debug == true {
+				fmt.Printf("Closed connection from %s to %s (%d active)\n", local.RemoteAddr(), target, active)
+			}
+		}()
+	}
+}
+
+// proxy_connection copies data in both directions until both sides are
+// done, then closes both connections.
+func proxy_connection(a net.Conn, b net.Conn) {
+	var wg sync.WaitGroup
+
+	pipe := func(dst net.Conn, src net.Conn) {
+		defer wg.Done()
+
+		io.Copy(dst, src)
+
+		// Pass on the end of the stream and keep reading the
+		// other direction
+		if c, ok := dst.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	wg.Add(2)
+
+	go pipe(a, b)
+	go pipe(b, a)
+
+	wg.Wait()
+
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+func env_get_known_hosts_path() (string, error) {
+	//*************************************************************
+	// Return the path of the known_hosts file for Cloud Shell
+	//*************************************************************
+
+	path, err := get_home_directory()
+
+	if err != nil {
+		return "", err
+	}
+
+	if isWindows() == true {
+		path += "\\.ssh\\cloudshell_known_hosts"
+	} else {
+		path += "/.ssh/cloudshell_known_hosts"
+	}
+
+	return path, nil
+}
+
+func host_key_policy() string {
+	if config.HostKeyPolicy == "" {
+		return cloudshell.HostKeyTOFU
+	}
+
+	return config.HostKeyPolicy
+}
+
+// openssh_host_key_args returns the OpenSSH client options that make ssh use
+// the same known_hosts file, alias and policy as this program.
+func openssh_host_key_args(params cloudshell.Environment) []string {
+	file, err := env_get_known_hosts_path()
+
+	if err != nil {
+		return nil
+	}
+
+	checking := "accept-new"
+
+	switch host_key_policy() {
+	case cloudshell.HostKeyStrict:
+		checking = "yes"
+
+	case cloudshell.HostKeyInsecure:
+		checking = "no"
+	}
+
+	return []string{
+		"-o", "HostKeyAlias=" + cloudshell.HostKeyAlias(params),
+		"-o", "UserKnownHostsFile=" + file,
+		"-o", "StrictHostKeyChecking=" + checking,
+	}
+}
+
+func cmd_hostkeys(ctx context.Context, client *cloudshell.Client) {
+	file, err := env_get_known_hosts_path()
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	switch config.HostKeysCommand {
+	case "list":
+		cmd_hostkeys_list(ctx, client, file)
+
+	case "reset":
+		cmd_hostkeys_reset(ctx, client, file)
+	}
+}
+
+func cmd_hostkeys_list(ctx context.Context, client *cloudshell.Client, file string) {
+	hosts, err := cloudshell.KnownHosts(file)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	current := ""
+
+	if params, err := client.GetEnvironment(ctx); err == nil {
+		current = cloudshell.HostKeyAlias(params)
+	}
+
+	fmt.Println("File:", file)
+
+	if len(hosts) == 0 {
+		fmt.Println("No host keys")
+		return
+	}
+
+	for _, h := range hosts {
+		line := strings.Join(h.Hosts, ",") + " " + h.Key.Type() + " " + ssh.FingerprintSHA256(h.Key)
+
+		for _, host := range h.Hosts {
+			if host == current {
+				line += " (current environment)"
+			}
+		}
+
+		fmt.Println(line)
+	}
+}
+
+func cmd_hostkeys_reset(ctx context.Context, client *cloudshell.Client, file string) {
+	alias := ""
+
+	if config.Flags.All == false {
+		params, err := client.GetEnvironment(ctx)
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+
+		alias = cloudshell.HostKeyAlias(params)
+	}
+
+	count, err := cloudshell.RemoveKnownHost(file, alias)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	fmt.Printf("Removed %d host key(s)\n", count)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+func key_comment() string {
+	name := "cloudshell"
+
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+
+	return name
+}
+
+func default_key_type() string {
+	//************************************************************
+	// The v1alpha1 API does not accept ed25519 keys
+	//************************************************************
+
+	if config.KeyType != "" {
+		return config.KeyType
+	}
+
+	if config.ApiVersion == cloudshell.APIVersionV1 {
+		return cloudshell.KeyTypeEd25519
+	}
+
+	return cloudshell.KeyTypeRSA
+}
+
+func cmd_keys(ctx context.Context, client *cloudshell.Client) {
+	switch config.KeysCommand {
+	case "init":
+		cmd_keys_init(ctx, client)
+
+	case "list":
+		cmd_keys_list(ctx, client)
+
+	case "add":
+		cmd_keys_add(ctx, client)
+
+	case "remove":
+		cmd_keys_remove(ctx, client)
+
+	case "rotate":
+		cmd_keys_rotate(ctx, client)
+
+	case "export-ppk":
+		cmd_keys_export_ppk()
+	}
+}
+
+func key_fingerprint(key string) string {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+
+	if err != nil {
+		return "(invalid key)"
+	}
+
+	return ssh.FingerprintSHA256(pub)
+}
+
+// resolve_key_arg returns the public key named on the command line. The
+// argument is a public key file, a key in authorized_keys format or, for
+// keys already registered with Cloud Shell, a SHA256 fingerprint.
+func resolve_key_arg(arg string, env cloudshell.Environment) (string, error) {
+	if strings.HasPrefix(arg, "SHA256:") {
+		for _, key := range env.PublicKeys {
+			if key_fingerprint(key) == arg {
+				return key, nil
+			}
+		}
+
+		return "", errors.New("No Cloud Shell public key has the fingerprint " + arg)
+	}
+
+	if fileExists(arg) {
+		data, err := ioutil.ReadFile(arg)
+
+		if err != nil {
+			return "", err
+		}
+
+		arg = string(data)
+	}
+
+	_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(arg))
+
+	if err != nil {
+		return "", errors.New("Not a public key file or public key: " + err.Error())
+	}
+
+	return strings.TrimSpace(arg), nil
+}
+
+func cmd_keys_list(ctx context.Context, client *cloudshell.Client) {
+	env, err := client.GetEnvironment(ctx)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	local := ""
+
+	if path, err := env_get_ssh_pkey_path(); err == nil {
+		local, _ = cloudshell.ReadPublicKeyFile(path)
+	}
+
+	if len(env.PublicKeys) == 0 {
+		fmt.Println("No public keys")
+		return
+	}
+
+	for _, key := range env.PublicKeys {
+		fields := strings.Fields(key)
+
+		line := key_fingerprint(key) + " " + fields[0]
+
+		if local != "" && key_fingerprint(key) == key_fingerprint(local) {
+			line += " (local key)"
+		}
+
+		fmt.Println(line)
+	}
+}
+
+func cmd_keys_add(ctx context.Context, client *cloudshell.Client) {
+	key, err := resolve_key_arg(config.KeyArg, cloudshell.Environment{})
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	added, err := client.RegisterPublicKey(ctx, key)
+
+	if err != nil {
+		fmt.Println("Error: Cannot add public key:", err)
+		set_exit_code(1)
+		return
+	}
+
+	if added == true {
+		fmt.Println("Added:", key_fingerprint(key))
+	} else {
+		fmt.Println("Already registered:", key_fingerprint(key))
+	}
+}
+
+func cmd_keys_remove(ctx context.Context, client *cloudshell.Client) {
+	env, err := client.GetEnvironment(ctx)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	key, err := resolve_key_arg(config.KeyArg, env)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	if env.HasPublicKey(key) == false {
+		fmt.Println("Error: Public key is not registered with Cloud Shell:", key_fingerprint(key))
+		set_exit_code(1)
+		return
+	}
+
+	err = client.UnregisterPublicKey(ctx, key)
+
+	if err != nil {
+		fmt.Println("Error: Cannot remove public key:", err)
+		set_exit_code(1)
+		return
+	}
+
+	fmt.Println("Removed:", key_fingerprint(key))
+}
+
+func cmd_keys_rotate(ctx context.Context, client *cloudshell.Client) {
+	path, err := env_get_ssh_pkey()
+
+	if err != nil {
+		set_exit_code(1)
+		return
+	}
+
+	old, err := cloudshell.ReadPublicKeyFile(path)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	key, err := client.RotateKey(ctx, path, default_key_type(), key_comment())
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+
+		if key == nil {
+			return
+		}
+	}
+
+	fmt.Println("Old key:", key_fingerprint(old))
+	fmt.Println("New key:", key_fingerprint(key.AuthorizedKey()))
+}
+
+func cmd_keys_init(ctx context.Context, client *cloudshell.Client) {
+	//************************************************************
+	// Create ~/.ssh/google_compute_engine unless it exists
+	//************************************************************
+
+	path, err := env_get_ssh_pkey_path()
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	if fileExists(path) == false || config.Flags.Force == true {
+		key, err := cloudshell.GenerateKey(default_key_type(), key_comment())
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+
+		err = key.WriteFiles(path)
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+
+		fmt.Println("Created SSH key:", path)
+	} else {
+		fmt.Println("Using existing SSH key:", path)
+	}
+
+	//************************************************************
+	// Register the public key with the Cloud Shell environment
+	//************************************************************
+
+	pub, err := cloudshell.ReadPublicKeyFile(path)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	added, err := client.RegisterPublicKey(ctx, pub)
+
+	if err != nil {
+		fmt.Println("Error: Cannot register public key:", err)
+		set_exit_code(1)
+		return
+	}
+
+	if added == true {
+		fmt.Println("Public key added to Cloud Shell")
+	} else {
+		fmt.Println("Public key is already registered with Cloud Shell")
+	}
+}
+
+func cmd_keys_export_ppk() {
+	//************************************************************
+	// Convert ~/.ssh/google_compute_engine to PuTTY format
+	//************************************************************
+
+	key, err := env_get_ssh_pkey()
+
+	if err != nil {
+		set_exit_code(1)
+		return
+	}
+
+	path := config.KeyArg
+
+	if path == "" {
+		path = key + ".ppk"
+	}
+
+	var passphrase []byte
+
+	if config.Flags.Passphrase == true {
+		passphrase, err = read_new_passphrase()
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			set_exit_code(1)
+			return
+		}
+	}
+
+	err = cloudshell.ConvertToPPK(key, path, key_passphrase, passphrase, config.PpkVersion)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	fmt.Println("Created:", path)
+}
@@ -40,6 +40,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	handle_signals()
+
 	//************************************************************
 	// Using Cloud SDK User Credentials does not work with Cloud Shell
 	//
@@ -68,8 +70,9 @@ func main() {
 		os.Exit(1)
 	}
 
-	// FIX - return error numbers so that scripts/tools can detect errors
 	call_cloud_shell(accessToken)
 
-	os.Exit(0)
+	run_exit_handlers()
+
+	os.Exit(exit_code)
 }
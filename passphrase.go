@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Passphrases of private key files that were verified in this process.
+// key_passphrase_mutex is held from the lookup to the store so that
+// concurrent connections prompt only once.
+var key_passphrases = map[string][]byte{}
+var key_passphrase_mutex sync.Mutex
+
+func read_passphrase(prompt string) ([]byte, error) {
+	//************************************************************
+	// Read a passphrase from the terminal without echo
+	//************************************************************
+
+	fd := int(os.Stdin.Fd())
+
+	if term.IsTerminal(fd) == false {
+		return nil, errors.New("Cannot prompt for a passphrase: standard input is not a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+
+	passphrase, err := term.ReadPassword(fd)
+
+	fmt.Fprintln(os.Stderr)
+
+	return passphrase, err
+}
+
+func read_new_passphrase() ([]byte, error) {
+	passphrase, err := read_passphrase("Enter passphrase: ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	confirm, err := read_passphrase("Enter same passphrase again: ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(passphrase, confirm) == false {
+		return nil, errors.New("Passphrases do not match")
+	}
+
+	return passphrase, nil
+}
+
+func key_passphrase(file string) ([]byte, error) {
+	//************************************************************
+	// Return the passphrase of an encrypted private key file from,
+	// in order: the cache, CLOUDSHELL_KEY_PASSPHRASE, the
+	// passphrase command or an interactive prompt
+	//************************************************************
+
+	key_passphrase_mutex.Lock()
+	defer key_passphrase_mutex.Unlock()
+
+	if passphrase, ok := key_passphrases[file]; ok {
+		return passphrase, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	check := func(passphrase []byte) error {
+		_, err := ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+
+		if err == nil {
+			key_passphrases[file] = passphrase
+		}
+
+		return err
+	}
+
+	if v, ok := os.LookupEnv("CLOUDSHELL_KEY_PASSPHRASE"); ok {
+		passphrase := []byte(v)
+
+		if err := check(passphrase); err != nil {
+			return nil, fmt.Errorf("CLOUDSHELL_KEY_PASSPHRASE: %w", err)
+		}
+
+		return passphrase, nil
+	}
+
+	if config.PassphraseCommand != "" {
+		passphrase, err := run_passphrase_command(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := check(passphrase); err != nil {
+			return nil, fmt.Errorf("passphrase command: %w", err)
+		}
+
+		return passphrase, nil
+	}
+
+	for x := 0; x < 3; x++ {
+		passphrase, err := read_passphrase("Enter passphrase for key '" + file + "': ")
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = check(passphrase)
+
+		if err == nil {
+			return passphrase, nil
+		}
+
+		fmt.Fprintln(os.Stderr, "Bad passphrase, try again")
+	}
+
+	return nil, errors.New("Too many incorrect passphrases for " + file)
+}
+
+func run_passphrase_command(file string) ([]byte, error) {
+	//************************************************************
+	// Run the passphrase command with the key file in
+	// CLOUDSHELL_KEY_FILE and return its first line of output
+	//************************************************************
+
+	var cmd *exec.Cmd
+
+	if isWindows() == true {
+		cmd = exec.Command("cmd.exe", "/C", config.PassphraseCommand)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", config.PassphraseCommand)
+	}
+
+	cmd.Env = append(os.Environ(), "CLOUDSHELL_KEY_FILE=" + file)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("passphrase command: %w", err)
+	}
+
+	if n := bytes.IndexAny(out, "\r\n"); n >= 0 {
+		out = out[:n]
+	}
+
+	return out, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+// Cloud Shell Web Preview uses port 8080 by default
+const default_preview_port = 8080
+
+func cmd_preview(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	//************************************************************
+	// Forward a free local port to the preview port in Cloud
+	// Shell and open it in the browser
+	//************************************************************
+
+	tunnel, err := open_tunnel(ctx, client, params)
+
+	if err != nil {
+		return
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	at_exit(func() {
+		listener.Close()
+	})
+
+	target := net.JoinHostPort("localhost", strconv.Itoa(config.PreviewPort))
+
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	url := fmt.Sprintf("http://localhost:%d/", port)
+
+	fmt.Println("Web Preview of port", config.PreviewPort, "in Cloud Shell:", url)
+
+	err = open_browser(url)
+
+	if err != nil {
+		fmt.Println("Cannot open the browser:", err)
+		fmt.Println("Open the URL in your browser")
+	}
+
+	fmt.Println("Press Ctrl-C to stop")
+
+	forward_accept(listener, target, func() (net.Conn, error) {
+		return tunnel.Dial(ctx, "tcp", target)
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"golang.org/x/term"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+//******************************************************************************************
+// Transfer progress
+//
+// On a terminal one status line is redrawn in place. Otherwise, for example
+// when the output is redirected to a file, a plain line is printed every
+// few seconds. Transfers of several files show the overall progress
+// followed by the progress of the current file.
+//******************************************************************************************
+
+const (
+	progress_tty_interval   = 200 * time.Millisecond
+	progress_plain_interval = 5 * time.Second
+)
+
+type transfer_progress struct {
+	mu sync.Mutex
+
+	tty   bool
+	width int
+
+	// Length of the status line on the terminal
+	drawn int
+
+	// Expected files and bytes
+	total cloudshell.TransferSummary
+
+	// Files that are finished and their size
+	files int
+	bytes int64
+
+	// Bytes copied by this run, excluding resumed parts
+	copied int64
+
+	current         cloudshell.Progress
+	active          bool
+	file_start      time.Time
+	file_start_done int64
+
+	start time.Time
+	last  time.Time
+}
+
+func new_transfer_progress() *transfer_progress {
+	fd := int(os.Stdout.Fd())
+
+	p := &transfer_progress{
+		tty:   term.IsTerminal(fd),
+		start: time.Now(),
+	}
+
+	if p.tty == true {
+		if width, _, err := term.GetSize(fd); err == nil {
+			p.width = width - 1
+		}
+	} else {
+		p.last = p.start
+	}
+
+	return p
+}
+
+func (p *transfer_progress) set_total(total cloudshell.TransferSummary) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total = total
+	p.start = time.Now()
+}
+
+// update is called by the transfer as bytes are copied.
+func (p *transfer_progress) update(fp cloudshell.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	if p.active == false || fp.Src != p.current.Src {
+		p.active = true
+		p.current = fp
+		p.file_start = now
+		p.file_start_done = fp.Done
+	}
+
+	p.copied += fp.Done - p.current.Done
+	p.current = fp
+
+	interval := progress_plain_interval
+
+	if p.tty == true {
+		interval = progress_tty_interval
+	}
+
+	if now.Sub(p.last) < interval {
+		return
+	}
+
+	p.last = now
+
+	p.draw(p.line(now))
+}
+
+// file_done is called by the transfer when a file is finished.
+func (p *transfer_progress) file_done(r cloudshell.FileResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+
+	print_file_result(r)
+
+	p.files++
+
+	if p.active == true && p.current.Src == r.Src {
+		p.bytes += p.current.Size
+	}
+
+	p.active = false
+}
+
+// println prints a line above the status line.
+func (p *transfer_progress) println(a ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+
+	fmt.Println(a...)
+}
+
+// finish removes the status line.
+func (p *transfer_progress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clear()
+}
+
+func (p *transfer_progress) line(now time.Time) string {
+	pr := message.NewPrinter(language.English)
+
+	name := filepath.Base(p.current.Src)
+
+	file_rate := progress_rate(p.current.Done - p.file_start_done, now.Sub(p.file_start))
+
+	if p.total.Files <= 1 {
+		return pr.Sprintf("%s %s %d of %d bytes %d KB/s ETA %s",
+			name,
+			progress_percent(p.current.Done, p.current.Size),
+			p.current.Done,
+			p.current.Size,
+			file_rate / 1024,
+			progress_eta(p.current.Size - p.current.Done, file_rate))
+	}
+
+	done := p.bytes + p.current.Done
+
+	total_rate := progress_rate(p.copied, now.Sub(p.start))
+
+	return pr.Sprintf("[%d/%d] %s %d of %d bytes %d KB/s ETA %s - %s %s",
+		p.files + 1,
+		p.total.Files,
+		progress_percent(done, p.total.Bytes),
+		done,
+		p.total.Bytes,
+		total_rate / 1024,
+		progress_eta(p.total.Bytes - done, total_rate),
+		name,
+		progress_percent(p.current.Done, p.current.Size))
+}
+
+func (p *transfer_progress) draw(line string) {
+	if p.tty == false {
+		fmt.Println(line)
+		return
+	}
+
+	// A line longer than the terminal would wrap
+	if p.width > 0 && len(line) > p.width {
+		line = line[:p.width]
+	}
+
+	// Erase the rest of a longer previous line
+	pad := ""
+
+	if p.drawn > len(line) {
+		pad = strings.Repeat(" ", p.drawn - len(line))
+	}
+
+	fmt.Print("\r" + line + pad + "\r" + line)
+
+	p.drawn = len(line)
+}
+
+func (p *transfer_progress) clear() {
+	if p.drawn == 0 {
+		return
+	}
+
+	fmt.Print("\r" + strings.Repeat(" ", p.drawn) + "\r")
+
+	p.drawn = 0
+
+	// Redraw on the next update
+	p.last = time.Time{}
+}
+
+// progress_rate returns bytes per second.
+func progress_rate(n int64, d time.Duration) int64 {
+	if d < time.Second / 10 {
+		return 0
+	}
+
+	return int64(float64(n) / d.Seconds())
+}
+
+func progress_percent(n, total int64) string {
+	if total <= 0 {
+		return "100%"
+	}
+
+	return fmt.Sprintf("%3d%%", n * 100 / total)
+}
+
+// progress_eta formats the time to copy n bytes at rate bytes per second.
+func progress_eta(n, rate int64) string {
+	if rate <= 0 {
+		return "--:--"
+	}
+
+	s := n / rate
+
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s / 3600, s / 60 % 60, s % 60)
+	}
+
+	return fmt.Sprintf("%02d:%02d", s / 60, s % 60)
+}
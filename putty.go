@@ -3,13 +3,16 @@ package main
 import (
 	"fmt"
 	"os/exec"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
-func exec_putty(params CloudShellEnv) {
+func exec_putty(params cloudshell.Environment) {
 	key, err := env_get_ssh_ppk()
 
 	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
+		fmt.Println("\nTip: Run the command: \"cloudshell keys init\" to setup Cloud Shell SSH keys")
+		set_exit_code(1)
 		return
 	}
 
@@ -32,6 +35,7 @@ func exec_putty(params CloudShellEnv) {
 
 	if err != nil {
 		fmt.Println(err)
+		set_exit_code(1)
 		return
 	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+func cmd_run(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	//************************************************************
+	// Send a local script on the standard input of its
+	// interpreter in Cloud Shell. Nothing is written to disk.
+	//************************************************************
+
+	var script []byte
+	var err error
+
+	if config.ScriptFile == "-" {
+		script, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		script, err = ioutil.ReadFile(config.ScriptFile)
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	command := cloudshell.ScriptCommand(script, config.ScriptArgs...)
+
+	if config.Debug == true {
+		fmt.Println("Run Script:", command)
+	}
+
+	if config.Flags.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, config.Flags.Timeout)
+		defer cancel()
+	}
+
+	err = client.Run(ctx, params, &cloudshell.Command{
+		Command: command,
+		Env:     config.ExecEnv,
+		Dir:     config.ExecDir,
+		Stdin:   bytes.NewReader(script),
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	})
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Fprintln(os.Stderr, "Error: Script timed out after", config.Flags.Timeout)
+
+		// Same exit code as timeout(1)
+		set_exit_code(124)
+		return
+	}
+
+	set_exit_code(ssh_exit_code(err))
+
+	if err != nil {
+		if _, ok := err.(*ssh.ExitError); !ok {
+			print_ssh_error(err)
+		}
+	}
+}
@@ -1,158 +1,104 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"net"
-	"os"
 	"time"
-	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
-func sftp_download(params CloudShellEnv) {
-	file, err := env_get_ssh_pkey()
+func sftp_download(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	if config.Debug == true {
+		fmt.Println("Download:", config.SrcFiles, "->", config.DstFile)
+	}
+
+	progress := new_transfer_progress()
+
+	t, err := sftp_open_transfer(ctx, client, params, progress)
 
 	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
 		return
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: params.SshUsername,
-		Auth: []ssh.AuthMethod{
-			PublicKeyFile(file),
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-	}
+	defer t.Close()
 
-	sshHost := params.SshHost
-	sshPort := fmt.Sprint(params.SshPort)
+	progress.set_total(t.DownloadTotal(config.SrcFiles))
 
-	host := sshHost + ":" + sshPort
+	summary, err := t.DownloadFiles(ctx, config.SrcFiles, config.DstFile)
 
-	if config.Debug == true {
-		fmt.Println("Dial")
-		fmt.Println(host)
-	}
+	progress.finish()
 
-	connection, err := ssh.Dial("tcp", host, sshConfig)
+	print_transfer_summary(summary, err)
+}
 
-	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
-		return
+func sftp_upload(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	if config.Debug == true {
+		fmt.Println("Upload:", config.SrcFiles, "->", config.DstFile)
 	}
 
-	defer connection.Close()
+	progress := new_transfer_progress()
 
-	client, err := sftp.NewClient(connection)
+	t, err := sftp_open_transfer(ctx, client, params, progress)
 
 	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
 		return
 	}
 
-	defer client.Close()
+	defer t.Close()
 
-	// open source file
-	fmt.Println("open source file")
-	srcFile, err := client.Open(config.SrcFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer srcFile.Close()
- 
-	// create destination file
-	fmt.Println("create destination file")
-	dstFile, err := os.Create(config.DstFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer dstFile.Close()
+	progress.set_total(t.UploadTotal(config.SrcFiles))
 
-	// copy source file to destination file
-	bytes, err := io.Copy(dstFile, srcFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Printf("%d bytes copied\n", bytes)
-}
+	summary, err := t.UploadFiles(ctx, config.SrcFiles, config.DstFile)
 
-func sftp_upload(params CloudShellEnv) {
-	file, err := env_get_ssh_pkey()
+	progress.finish()
 
-	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
+	print_transfer_summary(summary, err)
+}
+
+// print_file_result prints the result of copying one file.
+func print_file_result(r cloudshell.FileResult) {
+	if r.Err != nil {
+		fmt.Println("Error:", r.Src + ":", r.Err)
 		return
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: params.SshUsername,
-		Auth: []ssh.AuthMethod{
-			PublicKeyFile(file),
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-	}
+	p := message.NewPrinter(language.English)
 
-	sshHost := params.SshHost
-	sshPort := fmt.Sprint(params.SshPort)
+	msg := p.Sprintf("%d bytes", r.Size)
 
-	host := sshHost + ":" + sshPort
+	if r.Resumed != 0 {
+		msg += p.Sprintf(" (resumed at %d)", r.Resumed)
+	}
 
-	if config.Debug == true {
-		fmt.Println("Dial")
-		fmt.Println(host)
+	if r.SHA256 != "" {
+		msg += ", SHA-256 verified " + r.SHA256
 	}
 
-	connection, err := ssh.Dial("tcp", host, sshConfig)
+	fmt.Println(r.Src, "->", r.Dst, msg)
+}
 
+// print_transfer_summary prints the totals of a transfer and sets a
+// non-zero exit code if any file failed.
+func print_transfer_summary(summary cloudshell.TransferSummary, err error) {
 	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
-		return
+		print_ssh_error(err)
+		set_exit_code(1)
 	}
 
-	defer connection.Close()
-
-	client, err := sftp.NewClient(connection)
-
-	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
+	if summary.Files + summary.Failed == 0 {
 		return
 	}
 
-	defer client.Close()
+	p := message.NewPrinter(language.English)
 
-	// open source file
-	srcFile, err := os.Open(config.SrcFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer srcFile.Close()
+	fmt.Println(p.Sprintf("%d files, %d bytes copied, %d failed", summary.Files, summary.Bytes, summary.Failed))
 
-	// create destination file
-	dstFile, err := client.Create(config.DstFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer dstFile.Close()
- 
-	// copy source file to destination file
-	bytes, err := io.Copy(dstFile, srcFile)
-	if err != nil {
-		log.Fatal(err)
+	if summary.Failed != 0 {
+		set_exit_code(1)
 	}
-	fmt.Printf("%d bytes copied\n", bytes)
 }
 
 func print_transfer_stats(total_time time.Duration, bytes int64, flag bool) {
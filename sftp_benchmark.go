@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"strconv"
@@ -8,15 +9,22 @@ import (
 	"time"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
 
-func sftp_benchmark_download(params CloudShellEnv) {
+func sftp_benchmark_download(ctx context.Context, cs *cloudshell.Client, params cloudshell.Environment) {
+	if config.Parallel > 1 {
+		sftp_benchmark_parallel(ctx, cs, params, true)
+		return
+	}
+
 	//************************************************************
 	//
 	//************************************************************
 
-	connection, client, err := sftp_open_connection(params)
+	connection, client, err := sftp_open_connection(ctx, cs, params)
 
 	if err != nil {
 		return
@@ -36,7 +44,8 @@ func sftp_benchmark_download(params CloudShellEnv) {
 	r, err := client.Open("/dev/zero")
 
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("Error:", err)
+		set_exit_code(1)
 		return
 	}
 
@@ -107,12 +116,17 @@ func sftp_benchmark_download(params CloudShellEnv) {
 	return
 }
 
-func sftp_benchmark_upload(params CloudShellEnv) {
+func sftp_benchmark_upload(ctx context.Context, cs *cloudshell.Client, params cloudshell.Environment) {
+	if config.Parallel > 1 {
+		sftp_benchmark_parallel(ctx, cs, params, false)
+		return
+	}
+
 	//************************************************************
 	//
 	//************************************************************
 
-	connection, client, err := sftp_open_connection(params)
+	connection, client, err := sftp_open_connection(ctx, cs, params)
 
 	if err != nil {
 		return
@@ -132,7 +146,8 @@ func sftp_benchmark_upload(params CloudShellEnv) {
 	w, err := client.OpenFile("/dev/null", syscall.O_WRONLY)
 
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println("Error:", err)
+		set_exit_code(1)
 		return
 	}
 
@@ -160,7 +175,7 @@ func sftp_benchmark_upload(params CloudShellEnv) {
 
 	p := message.NewPrinter(language.English)
 
-	fmt.Printf("downloading %v bytes\n", p.Sprintf("%d", config.benchmark_size))
+	fmt.Printf("uploading %v bytes\n", p.Sprintf("%d", config.benchmark_size))
 
 	//************************************************************
 	//
@@ -210,3 +225,80 @@ func sftp_benchmark_upload(params CloudShellEnv) {
 
 	return
 }
+
+//******************************************************************************************
+// Benchmark of parallel chunked transfers (--parallel, --chunk-size)
+//******************************************************************************************
+
+// Discards what is written
+type discard_writer_at struct{}
+
+func (discard_writer_at) WriteAt(p []byte, off int64) (int, error) {
+	return len(p), nil
+}
+
+// Repeats a buffer of random bytes
+type random_reader_at struct {
+	buffer []byte
+}
+
+func (r random_reader_at) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		n += copy(p[n:], r.buffer[(off + int64(n)) % int64(len(r.buffer)):])
+	}
+
+	return n, nil
+}
+
+func sftp_benchmark_parallel(ctx context.Context, cs *cloudshell.Client, params cloudshell.Environment, download bool) {
+	t, err := sftp_open_transfer(ctx, cs, params, nil)
+
+	if err != nil {
+		return
+	}
+
+	defer t.Close()
+
+	chunk := config.ChunkSize
+
+	if chunk == 0 {
+		chunk = cloudshell.DefaultChunkSize
+	}
+
+	p := message.NewPrinter(language.English)
+
+	direction := "uploading"
+
+	if download == true {
+		direction = "downloading"
+	}
+
+	fmt.Println(p.Sprintf("%s %d bytes over %d connections in %d byte chunks", direction, config.benchmark_size, config.Parallel, chunk))
+
+	// Connect before starting the clock
+	t.ReadRemote(ctx, "/dev/zero", discard_writer_at{}, 0, 1)
+
+	var count int64
+
+	t1 := time.Now()
+
+	if download == true {
+		count, err = t.ReadRemote(ctx, "/dev/zero", discard_writer_at{}, 0, config.benchmark_size)
+	} else {
+		buffer := make([]byte, 1024 * 1024)
+
+		rand.Read(buffer)
+
+		count, err = t.WriteRemote(ctx, "/dev/null", random_reader_at{buffer}, 0, config.benchmark_size)
+	}
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	print_transfer_stats(time.Since(t1), count, true)
+}
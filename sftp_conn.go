@@ -1,54 +1,48 @@
 package main
 
 import (
-	"fmt"
-	"net"
+	"context"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
-func sftp_open_connection(params CloudShellEnv) (*ssh.Client, *sftp.Client, error) {
-	file, err := env_get_ssh_pkey()
+func sftp_open_connection(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) (*ssh.Client, *sftp.Client, error) {
+	connection, sftpClient, err := client.OpenSFTP(ctx, params)
 
 	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
+		print_ssh_error(err)
 		return nil, nil, err
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: params.SshUsername,
-		Auth: []ssh.AuthMethod{
-			PublicKeyFile(file),
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-	}
-
-	sshHost := params.SshHost
-	sshPort := fmt.Sprint(params.SshPort)
-
-	host := sshHost + ":" + sshPort
+	return connection, sftpClient, nil
+}
 
-	if config.Debug == true {
-		fmt.Println("Dial: " + host)
+// sftp_open_transfer opens a transfer with the command line options.
+// progress may be nil.
+func sftp_open_transfer(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment, progress *transfer_progress) (*cloudshell.Transfer, error) {
+	opts := &cloudshell.TransferOptions{
+		Recursive: config.Flags.Recursive,
+		Resume:    config.Flags.Resume,
+		Verify:    config.Flags.Verify,
+		Parallel:  config.Parallel,
+		ChunkSize: config.ChunkSize,
+		OnFile:    print_file_result,
 	}
 
-	connection, err := ssh.Dial("tcp", host, sshConfig)
-
-	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
-		return nil, nil, err
+	if progress != nil {
+		opts.OnFile = progress.file_done
+		opts.OnProgress = progress.update
 	}
 
-	client, err := sftp.NewClient(connection)
+	t, err := client.NewTransfer(ctx, params, opts)
 
 	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
-		return nil, nil, err
+		print_ssh_error(err)
+		set_exit_code(1)
+		return nil, err
 	}
 
-	return connection, client, nil
+	return t, nil
 }
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+//******************************************************************************************
+// SOCKS5 proxy
+//
+// https://datatracker.ietf.org/doc/html/rfc1928
+// https://datatracker.ietf.org/doc/html/rfc1929 (username/password)
+//
+// Only CONNECT is supported. Host names are resolved in Cloud Shell.
+//******************************************************************************************
+
+const (
+	socks_version = 5
+
+	socks_auth_none     = 0x00
+	socks_auth_password = 0x02
+	socks_auth_refused  = 0xff
+
+	socks_cmd_connect = 1
+
+	socks_atyp_ipv4   = 1
+	socks_atyp_domain = 3
+	socks_atyp_ipv6   = 4
+
+	socks_rep_success            = 0x00
+	socks_rep_failure            = 0x01
+	socks_rep_not_allowed        = 0x02
+	socks_rep_connection_refused = 0x05
+	socks_rep_cmd_not_supported  = 0x07
+	socks_rep_atyp_not_supported = 0x08
+)
+
+// Timeout for a client to complete the SOCKS handshake
+const socks_handshake_timeout = 30 * time.Second
+
+// Number of proxied connections that are open
+var socks_active int64
+
+func cmd_socks(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	password, err := socks_password()
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	tunnel, err := open_tunnel(ctx, client, params)
+
+	if err != nil {
+		return
+	}
+
+	listener, err := net.Listen("tcp", config.SocksListen)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
+		return
+	}
+
+	at_exit(func() {
+		listener.Close()
+	})
+
+	fmt.Println("SOCKS5 proxy listening on", listener.Addr())
+
+	if config.SocksUser != "" {
+		fmt.Println("Username:", config.SocksUser)
+	}
+
+	fmt.Println("Press Ctrl-C to stop")
+
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			if config.Debug == true {
+				fmt.Println("Accept:", err)
+			}
+
+			return
+		}
+
+		go socks_serve(ctx, tunnel, conn, password)
+	}
+}
+
+// socks_password returns the password for --socks-user from the
+// environment variable CLOUDSHELL_SOCKS_PASSWORD or a prompt.
+func socks_password() ([]byte, error) {
+	if config.SocksUser == "" {
+		return nil, nil
+	}
+
+	if v, ok := os.LookupEnv("CLOUDSHELL_SOCKS_PASSWORD"); ok {
+		return []byte(v), nil
+	}
+
+	password, err := read_passphrase("SOCKS password for " + config.SocksUser + ": ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(password) == 0 {
+		return nil, errors.New("The SOCKS password cannot be empty")
+	}
+
+	confirm, err := read_passphrase("Enter the SOCKS password again: ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(password, confirm) == false {
+		return nil, errors.New("The SOCKS passwords do not match")
+	}
+
+	return password, nil
+}
+
+func socks_serve(ctx context.Context, tunnel *cloudshell.Tunnel, conn net.Conn, password []byte) {
+	conn.SetDeadline(time.Now().Add(socks_handshake_timeout))
+
+	target, err := socks_handshake(conn, password)
+
+	if err != nil {
+		if config.Debug == true {
+			fmt.Println("SOCKS:", conn.RemoteAddr(), err)
+		}
+
+		conn.Close()
+		return
+	}
+
+	remote, err := tunnel.Dial(ctx, "tcp", target)
+
+	if err != nil {
+		fmt.Printf("[%d active] CONNECT %s from %s failed: %v\n", atomic.LoadInt64(&socks_active), target, conn.RemoteAddr(), err)
+
+		socks_reply(conn, socks_dial_error(err))
+		conn.Close()
+		return
+	}
+
+	err = socks_reply(conn, socks_rep_success)
+
+	if err != nil {
+		remote.Close()
+		conn.Close()
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	active := atomic.AddInt64(&socks_active, 1)
+
+	fmt.Printf("[%d active] CONNECT %s from %s\n", active, target, conn.RemoteAddr())
+
+	start := time.Now()
+
+	proxy_connection(conn, remote)
+
+	active = atomic.AddInt64(&socks_active, -1)
+
+	fmt.Printf("[%d active] CLOSE %s from %s after %s\n", active, target, conn.RemoteAddr(), time.Since(start).Round(time.Millisecond))
+}
+
+// socks_handshake performs method negotiation, authentication and reads the
+// request. It returns the destination as host:port.
+func socks_handshake(conn net.Conn, password []byte) (string, error) {
+	//************************************************************
+	// Method selection
+	//************************************************************
+
+	header := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+
+	if header[0] != socks_version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socks_auth_none)
+
+	if password != nil {
+		method = socks_auth_password
+	}
+
+	offered := false
+
+	for _, m := range methods {
+		if m == method {
+			offered = true
+		}
+	}
+
+	if offered == false {
+		conn.Write([]byte{socks_version, socks_auth_refused})
+		return "", errors.New("no acceptable authentication method")
+	}
+
+	if _, err := conn.Write([]byte{socks_version, method}); err != nil {
+		return "", err
+	}
+
+	if method == socks_auth_password {
+		if err := socks_authenticate(conn, password); err != nil {
+			return "", err
+		}
+	}
+
+	//************************************************************
+	// Request
+	//************************************************************
+
+	request := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+
+	if request[0] != socks_version {
+		return "", fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+
+	var host string
+
+	switch request[3] {
+	case socks_atyp_ipv4, socks_atyp_ipv6:
+		size := net.IPv4len
+
+		if request[3] == socks_atyp_ipv6 {
+			size = net.IPv6len
+		}
+
+		ip := make([]byte, size)
+
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+
+		host = net.IP(ip).String()
+
+	case socks_atyp_domain:
+		size := make([]byte, 1)
+
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return "", err
+		}
+
+		name := make([]byte, size[0])
+
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+
+		host = string(name)
+
+	default:
+		socks_reply(conn, socks_rep_atyp_not_supported)
+		return "", fmt.Errorf("unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	if request[1] != socks_cmd_connect {
+		socks_reply(conn, socks_rep_cmd_not_supported)
+		return "", fmt.Errorf("unsupported command %d", request[1])
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0]) << 8 | int(port[1]))), nil
+}
+
+// socks_authenticate performs the username/password sub-negotiation.
+func socks_authenticate(conn net.Conn, password []byte) error {
+	header := make([]byte, 2)
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+
+	user := make([]byte, header[1])
+
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+
+	size := make([]byte, 1)
+
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return err
+	}
+
+	pass := make([]byte, size[0])
+
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return err
+	}
+
+	userOk := subtle.ConstantTimeCompare(user, []byte(config.SocksUser))
+	passOk := subtle.ConstantTimeCompare(pass, password)
+
+	if userOk & passOk != 1 {
+		conn.Write([]byte{1, 1})
+		return errors.New("authentication failed for user " + strconv.Quote(string(user)))
+	}
+
+	_, err := conn.Write([]byte{1, 0})
+
+	return err
+}
+
+func socks_reply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{socks_version, rep, 0, socks_atyp_ipv4, 0, 0, 0, 0, 0, 0})
+
+	return err
+}
+
+// socks_dial_error maps a direct-tcpip error to a SOCKS reply code.
+func socks_dial_error(err error) byte {
+	var open *ssh.OpenChannelError
+
+	if errors.As(err, &open) {
+		switch open.Reason {
+		case ssh.Prohibited:
+			return socks_rep_not_allowed
+
+		case ssh.ConnectionFailed:
+			return socks_rep_connection_refused
+		}
+	}
+
+	return socks_rep_failure
+}
@@ -3,140 +3,87 @@ package main
 // https://github.com/kirinlabs/HttpRequest
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net"
 	"os"
-	"os/exec"
-	"time"
 	"golang.org/x/crypto/ssh"
-)
 
-func PublicKeyFile(file string) ssh.AuthMethod {
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
 
-	buffer, err := ioutil.ReadFile(file)
+func exec_command(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	//************************************************************
+	// Stream the output of the remote command to the local
+	// stdout and stderr, and exit with its exit status
+	//************************************************************
 
-	if err != nil {
-		fmt.Println(err)
-		return nil
+	if config.Debug == true {
+		fmt.Println("Run Command:", config.RemoteCommand)
 	}
 
-	key, err := ssh.ParsePrivateKey(buffer)
+	if config.Flags.Timeout > 0 {
+		var cancel context.CancelFunc
 
-	if err != nil {
-		fmt.Println(err)
-		return nil
+		ctx, cancel = context.WithTimeout(ctx, config.Flags.Timeout)
+		defer cancel()
 	}
 
-	return ssh.PublicKeys(key)
-}
-
-func exec_command(params CloudShellEnv) {
-	file, err := env_get_ssh_pkey()
-
-	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
+	err := client.Run(ctx, params, &cloudshell.Command{
+		Command: config.RemoteCommand,
+		Env:     config.ExecEnv,
+		Dir:     config.ExecDir,
+		TTY:     config.Flags.Tty,
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	})
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		fmt.Fprintln(os.Stderr, "Error: Remote command timed out after", config.Flags.Timeout)
+
+		// Same exit code as timeout(1)
+		set_exit_code(124)
 		return
 	}
 
-	sshConfig := &ssh.ClientConfig{
-		User: params.SshUsername,
-		Auth: []ssh.AuthMethod{
-			PublicKeyFile(file),
-		},
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-	}
-
-	sshHost := params.SshHost
-	sshPort := fmt.Sprint(params.SshPort)
-
-	host := sshHost + ":" + sshPort
-
-	// fmt.Println("Dial")
-	// fmt.Println(host)
-
-	connection, err := ssh.Dial("tcp", host, sshConfig)
+	set_exit_code(ssh_exit_code(err))
 
 	if err != nil {
-		// return nil, fmt.Errorf("Failed to dial: %s", err)
-		fmt.Println(err)
-		return
+		if _, ok := err.(*ssh.ExitError); !ok {
+			print_ssh_error(err)
+		}
 	}
+}
 
-	defer connection.Close()
+func exec_ssh(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	//************************************************************
+	// Interactive shell with the built-in SSH client, so OpenSSH
+	// does not need to be installed
+	//************************************************************
 
-	if config.Debug == true {
-		fmt.Println("Connect")
-	}
+	err := client.Shell(ctx, params, os.Stdin, os.Stdout, os.Stderr)
 
-	session, err := connection.NewSession()
+	set_exit_code(ssh_exit_code(err))
 
 	if err != nil {
-		// return nil, fmt.Errorf("Failed to create session: %s", err)
-		fmt.Println(err)
-		return
-	}
-
-	defer session.Close()
-
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
-
-	session.Stdout = &stdoutBuf
-	session.Stderr = &stderrBuf
-
-	if config.Debug == true {
-		fmt.Println("Run Command:", config.RemoteCommand)
+		if _, ok := err.(*ssh.ExitError); !ok {
+			print_ssh_error(err)
+		}
 	}
-
-	session.Run(config.RemoteCommand)
-
-	fmt.Printf("%s\n", stdoutBuf.String())
-	fmt.Printf("%s\n", stderrBuf.String())
 }
 
-func exec_ssh(params CloudShellEnv) {
-	key, err := env_get_ssh_pkey()
-
-	if err != nil {
-		return
-	}
-
-	sshUsername := params.SshUsername
-	sshHost := params.SshHost
-	sshPort := fmt.Sprint(params.SshPort)
-	sshUrl := sshUsername + "@" + sshHost
-
-	if config.Debug == true {
-		fmt.Println(key)
-		fmt.Println(sshUsername)
-		fmt.Println(sshHost)
-		fmt.Println(sshPort)
-		fmt.Println(sshUrl)
+// ssh_exit_code returns the exit code for the result of a remote command.
+// As with OpenSSH, 255 is returned when the command did not run or its
+// exit status is unknown.
+func ssh_exit_code(err error) int {
+	if err == nil {
+		return 0
 	}
 
-	for x:= 0; x < 3; x++ {
-		if x > 0 {
-			fmt.Println("Retrying ...")
-
-			time.Sleep(1000 * time.Millisecond)
-		}
-
-		cmd := exec.Command("ssh", "-p", sshPort, "-i", key, sshUrl)
-
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-
-		err = cmd.Run()
-
-		if err == nil {
-			return
-		}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus()
 	}
 
-	fmt.Println(err)
+	return 255
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
+)
+
+//******************************************************************************************
+// Sync
+//
+// cloudshell sync ./dir :dir - make the directory in Cloud Shell the same as ./dir
+// cloudshell sync :dir ./dir - the reverse
+//
+// Only new and changed files are copied. The ignore files below are read in
+// each directory of the source.
+//******************************************************************************************
+
+var sync_ignore_files = []string{".gitignore", ".cloudshellignore"}
+
+func cmd_sync(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
+	if config.Debug == true {
+		fmt.Println("Sync:", config.SyncSrc, "->", config.SyncDst)
+	}
+
+	progress := new_transfer_progress()
+
+	t, err := sftp_open_transfer(ctx, client, params, progress)
+
+	if err != nil {
+		return
+	}
+
+	defer t.Close()
+
+	opts := &cloudshell.SyncOptions{
+		Delete:   config.Flags.Delete,
+		DryRun:   config.Flags.DryRun,
+		Checksum: config.Flags.Checksum,
+		Filter:   sync_filter(),
+		OnStart:  progress.set_total,
+		OnChange: func(c cloudshell.SyncChange) {
+			print_sync_change(progress, c)
+		},
+	}
+
+	var summary cloudshell.SyncSummary
+
+	if config.SyncUpload == true {
+		summary, err = t.SyncUpload(ctx, config.SyncSrc, config.SyncDst, opts)
+	} else {
+		summary, err = t.SyncDownload(ctx, config.SyncSrc, config.SyncDst, opts)
+	}
+
+	progress.finish()
+
+	if err != nil {
+		print_ssh_error(err)
+		set_exit_code(1)
+	}
+
+	p := message.NewPrinter(language.English)
+
+	if config.Flags.DryRun == true {
+		fmt.Println(p.Sprintf("Dry run: %d files (%d bytes) to copy, %d to delete, %d unchanged",
+			summary.Files, summary.Bytes, summary.Deleted, summary.Unchanged))
+	} else {
+		fmt.Println(p.Sprintf("%d files copied (%d bytes), %d deleted, %d unchanged, %d failed",
+			summary.Files, summary.Bytes, summary.Deleted, summary.Unchanged, summary.Failed))
+	}
+
+	if summary.Failed != 0 {
+		set_exit_code(1)
+	}
+}
+
+// print_sync_change prints a change before it is made. Copies are printed
+// when they are done, except in a dry run.
+func print_sync_change(progress *transfer_progress, c cloudshell.SyncChange) {
+	name := c.Path
+
+	if c.IsDir == true {
+		name += "/"
+	}
+
+	switch {
+	case config.Flags.DryRun == true && c.Op == cloudshell.SyncCopy:
+		p := message.NewPrinter(language.English)
+
+		progress.println(p.Sprintf("copy %s (%s, %d bytes)", name, c.Reason, c.Size))
+
+	case config.Flags.DryRun == true || c.Op == cloudshell.SyncDelete:
+		progress.println(c.Op, name)
+	}
+}
@@ -1,17 +1,31 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
+	"strings"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/MugenMBX8/google-cloud-shell-cli-go/cloudshell"
 )
 
 var path_winscp =  "C:\\Program Files (x86)\\WinSCP\\WinSCP.exe"
 
-func exec_winscp(params CloudShellEnv) {
+func exec_winscp(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) {
 	key, err := env_get_ssh_ppk()
 
 	if err != nil {
-		fmt.Println("\nTip: Run the command: \"gcloud alpha cloud-shell ssh --dry-run\" to setup Cloud Shell SSH keys")
+		fmt.Println("\nTip: Run the command: \"cloudshell keys init\" to setup Cloud Shell SSH keys")
+		set_exit_code(1)
+		return
+	}
+
+	hostkey, err := winscp_host_key(ctx, client, params)
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		set_exit_code(1)
 		return
 	}
 
@@ -20,7 +34,7 @@ func exec_winscp(params CloudShellEnv) {
 	sshPort := fmt.Sprint(params.SshPort)
 	sshUrl := "sftp://" + sshUsername + "@" + sshHost + ":" + sshPort
 
-	args := append([]string{"/ini=nul", "/privatekey=" + key, "/hostkey=*", sshUrl}, config.WinscpFlags...)
+	args := append([]string{"/ini=nul", "/privatekey=" + key, "/hostkey=" + hostkey, sshUrl}, config.WinscpFlags...)
 
 	if config.Debug == true {
 		fmt.Println(key)
@@ -39,6 +53,31 @@ func exec_winscp(params CloudShellEnv) {
 
 	if err != nil {
 		fmt.Println(err)
+		set_exit_code(1)
 		return
 	}
 }
+
+// winscp_host_key returns the value of the WinSCP /hostkey option. The host
+// key is verified (and on first use remembered) with an SSH connection, then
+// the SHA-256 fingerprints of all host keys of the server are passed to
+// WinSCP, separated by ";", as WinSCP may negotiate a different key type.
+func winscp_host_key(ctx context.Context, client *cloudshell.Client, params cloudshell.Environment) (string, error) {
+	if host_key_policy() == cloudshell.HostKeyInsecure {
+		return "*", nil
+	}
+
+	keys, err := client.HostKeys(ctx, params)
+
+	if err != nil {
+		return "", err
+	}
+
+	var fingerprints []string
+
+	for _, key := range keys {
+		fingerprints = append(fingerprints, strings.TrimPrefix(ssh.FingerprintSHA256(key), "SHA256:"))
+	}
+
+	return strings.Join(fingerprints, ";"), nil
+}